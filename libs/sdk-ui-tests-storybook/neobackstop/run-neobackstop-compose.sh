@@ -9,9 +9,10 @@ export USER_GID=$(id -g)
 
 cd $ROOT_DIR
 
-# copy storybook to serve app dir (skip when using pre-built images)
+# copy storybook and scenarios to serve app dir (skip when using pre-built images)
 if [ -z "$NEOBACKSTOP_IMAGE" ] && [ -z "$NEOBACKSTOP_SERVE_IMAGE" ]; then
     cp -r ./dist ./neobackstop/serve/dist
+    cp ./neobackstop/scenarios.json ./neobackstop/serve/scenarios.json
 fi
 
 # let "test" be the default command
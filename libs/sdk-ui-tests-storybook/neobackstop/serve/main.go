@@ -1,34 +1,137 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"log"
+	"net"
 	"os"
 	"path/filepath"
+	"strconv"
+	"time"
 
 	"github.com/valyala/fasthttp"
 )
 
+// requiredFiles must exist in the storybook root, the test runner loads them directly.
+var requiredFiles = []string{"index.html", "iframe.html"}
+
 func main() {
-	storybookPath := os.Args[1]
+	root := flag.String("root", "", "storybook static build to serve (may also be given as the first argument)")
+	addr := flag.String("addr", "", "address to listen on, empty for all interfaces")
+	port := flag.Int("port", 8080, "port to listen on")
+	tlsCert := flag.String("tls-cert", "", "PEM certificate file, enables HTTPS together with --tls-key")
+	tlsKey := flag.String("tls-key", "", "PEM private key file for --tls-cert")
+	scenariosPath := flag.String("scenarios", "", "scenarios.json whose iframe.html?id= targets must exist in the storybook index")
+	slowAsset := flag.Duration("slow-asset", time.Second, "requests of a traced scenario taking at least this long are reported as slow, 0 disables")
+	vhostsPath := flag.String("vhosts", "", "JSON file with virtual hosts served with their own roots and headers")
+	preload := flag.Bool("preload", false, "load the whole storybook build into memory at startup and serve it from there")
+	flag.Parse()
 
-	port := 8080
+	storybookPath := *root
+	if storybookPath == "" {
+		storybookPath = flag.Arg(0)
+	}
+	if storybookPath == "" {
+		fmt.Fprintln(os.Stderr, "missing storybook root, pass --root=<dir>")
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *port < 1 || *port > 65535 {
+		log.Fatalf("Invalid --port %d", *port)
+	}
+	if (*tlsCert == "") != (*tlsKey == "") {
+		log.Fatalf("--tls-cert and --tls-key must be used together")
+	}
 
 	absFolder, err := filepath.Abs(storybookPath)
 	if err != nil {
 		log.Fatalf("Failed to get absolute path: %v", err)
 	}
+	if info, err := os.Stat(absFolder); err != nil || !info.IsDir() {
+		log.Fatalf("Storybook root %s is not a directory", absFolder)
+	}
+	for _, name := range requiredFiles {
+		if _, err := os.Stat(filepath.Join(absFolder, name)); err != nil {
+			log.Fatalf("Storybook root %s has no %s, is it a storybook static build?", absFolder, name)
+		}
+	}
+
+	handler, err := newFilesHandler(absFolder, *preload)
+	if err != nil {
+		log.Fatalf("Failed to serve %s: %v", absFolder, err)
+	}
+
+	if *vhostsPath != "" {
+		vhosts, err := loadVirtualHosts(*vhostsPath)
+		if err != nil {
+			log.Fatalf("Invalid virtual hosts: %v", err)
+		}
+		router := &hostRouter{hosts: map[string]hostHandler{}, fallback: handler}
+		for _, v := range vhosts {
+			hostRoot, hostFiles := absFolder, handler
+			if v.Root != "" && v.Root != absFolder {
+				hostRoot = v.Root
+				if hostFiles, err = newFilesHandler(hostRoot, *preload); err != nil {
+					log.Fatalf("Failed to serve %s for %s: %v", hostRoot, v.Host, err)
+				}
+			}
+			router.hosts[v.Host] = hostHandler{handler: hostFiles, headers: v.Headers}
+			fmt.Printf("Virtual host %s: %s\n", v.Host, hostRoot)
+		}
+		handler = router.handle
+	}
 
-	fmt.Printf("Serving Storybook static build from: %s on port %d\n", absFolder, port)
+	index, err := loadStoryIndex(absFolder)
+	if err != nil {
+		if *scenariosPath != "" {
+			log.Fatalf("Cannot validate %s without the storybook index: %v", *scenariosPath, err)
+		}
+		log.Printf("WARNING: no storybook index, /__stories is disabled: %v", err)
+	} else {
+		fmt.Printf("Storybook index has %d stories\n", len(index.stories()))
+		if *scenariosPath != "" {
+			checked, err := index.validateScenarios(*scenariosPath)
+			if err != nil {
+				log.Fatalf("Invalid scenarios: %v", err)
+			}
+			fmt.Printf("All %d scenario targets exist in the storybook index\n", checked)
+		}
 
-	requestHandler := func(ctx *fasthttp.RequestCtx) {
-		path := absFolder + string(ctx.Path())
-		if string(ctx.Path()) == "/" {
-			path = absFolder + "/index.html"
+		files := handler
+		handler = func(ctx *fasthttp.RequestCtx) {
+			if string(ctx.Path()) == "/__stories" {
+				index.handle(ctx)
+				return
+			}
+			files(ctx)
 		}
+	}
+
+	handler = newTracer(*slowAsset).wrap(handler)
 
-		fasthttp.ServeFile(ctx, path)
+	listenAddr := net.JoinHostPort(*addr, strconv.Itoa(*port))
+	if *tlsCert != "" {
+		fmt.Printf("Serving Storybook static build from: %s on https://%s\n", absFolder, listenAddr)
+		log.Fatal(fasthttp.ListenAndServeTLS(listenAddr, *tlsCert, *tlsKey, handler))
 	}
 
-	log.Fatal(fasthttp.ListenAndServe(fmt.Sprintf(":%d", port), requestHandler))
+	fmt.Printf("Serving Storybook static build from: %s on http://%s\n", absFolder, listenAddr)
+	log.Fatal(fasthttp.ListenAndServe(listenAddr, handler))
+}
+
+// newFilesHandler serves dir from memory when preload is set, from the disk otherwise.
+func newFilesHandler(dir string, preload bool) (fasthttp.RequestHandler, error) {
+	if preload {
+		memory, err := newMemoryHandler(dir)
+		if err != nil {
+			return nil, err
+		}
+		return memory.handle, nil
+	}
+	static, err := newStaticHandler(dir)
+	if err != nil {
+		return nil, err
+	}
+	return static.handle, nil
 }
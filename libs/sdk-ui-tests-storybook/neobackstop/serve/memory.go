@@ -0,0 +1,233 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"log"
+	"mime"
+	"os"
+	"path"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// compressibleContentTypes get gzip/brotli variants when preloading, everything else is assumed to be
+// compressed already (images, fonts).
+var compressibleContentTypes = []string{
+	"text/",
+	"application/javascript",
+	"application/json",
+	"application/manifest+json",
+	"application/wasm",
+	"application/xml",
+	"image/svg+xml",
+}
+
+type encodedBody struct {
+	encoding string
+	body     []byte
+	etag     string
+}
+
+type memoryFile struct {
+	contentType string
+	modTime     time.Time
+	body        []byte
+	etag        string
+	variants    []encodedBody // in precompressedSuffixes preference order
+}
+
+// memoryHandler serves a storybook build loaded into memory at startup, with ETags, MIME types and
+// compressed variants computed once, so concurrent capture workers never wait for the disk.
+type memoryHandler struct {
+	files map[string]*memoryFile // slash-separated name relative to root
+	dirs  map[string]bool
+}
+
+func newMemoryHandler(dir string) (*memoryHandler, error) {
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer root.Close()
+	fsys := root.FS()
+
+	t0 := time.Now()
+	h := &memoryHandler{files: map[string]*memoryFile{}, dirs: map[string]bool{}}
+	err = fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			h.dirs[name] = true
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		body, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			// e.g. a symlink pointing outside of the root
+			log.Printf("WARNING: skipping %s: %v", name, err)
+			return nil
+		}
+		contentType := mime.TypeByExtension(path.Ext(name))
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		h.files[name] = &memoryFile{contentType: contentType, modTime: info.ModTime(), body: body}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	h.encodeAll()
+	h.printStats(dir, time.Since(t0))
+
+	return h, nil
+}
+
+// encodeAll computes ETags and compressed variants on all CPUs. Prebuilt .br/.gz siblings are used
+// instead of compressing when they are not older than the original.
+func (h *memoryHandler) encodeAll() {
+	names := make(chan string)
+	var wg sync.WaitGroup
+	for range runtime.NumCPU() {
+		wg.Go(func() {
+			for name := range names {
+				h.encode(name)
+			}
+		})
+	}
+	for name := range h.files {
+		names <- name
+	}
+	close(names)
+	wg.Wait()
+}
+
+func (h *memoryHandler) encode(name string) {
+	f := h.files[name]
+	sum := sha256.Sum256(f.body)
+	f.etag = hex.EncodeToString(sum[:])[:32]
+
+	compressible := isCompressible(f.contentType)
+	for _, p := range precompressedSuffixes {
+		var body []byte
+		if sibling, ok := h.files[name+p.suffix]; ok && !sibling.modTime.Before(f.modTime) {
+			body = sibling.body
+		} else if compressible {
+			switch p.encoding {
+			case "br":
+				body = fasthttp.AppendBrotliBytesLevel(nil, f.body, fasthttp.CompressBrotliDefaultCompression)
+			case "gzip":
+				body = fasthttp.AppendGzipBytesLevel(nil, f.body, fasthttp.CompressBestCompression)
+			}
+			// not worth a variant when it saves less than a tenth
+			if len(body) > len(f.body)*9/10 {
+				body = nil
+			}
+		}
+		if body != nil {
+			f.variants = append(f.variants, encodedBody{encoding: p.encoding, body: body, etag: f.etag + "-" + p.encoding})
+		}
+	}
+}
+
+func isCompressible(contentType string) bool {
+	for _, prefix := range compressibleContentTypes {
+		if strings.HasPrefix(contentType, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *memoryHandler) printStats(dir string, elapsed time.Duration) {
+	var original, compressed int
+	var variants int
+	for _, f := range h.files {
+		original += len(f.body)
+		for _, v := range f.variants {
+			compressed += len(v.body)
+			variants++
+		}
+	}
+	fmt.Printf("Preloaded %d files from %s in %s: %s original + %s in %d compressed variants = %s in memory\n",
+		len(h.files), dir, elapsed.Round(time.Millisecond),
+		formatBytes(original), formatBytes(compressed), variants, formatBytes(original+compressed))
+}
+
+func formatBytes(n int) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := unit, 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGT"[exp])
+}
+
+func (h *memoryHandler) handle(ctx *fasthttp.RequestCtx) {
+	if rejectTraversal(ctx) {
+		return
+	}
+	if !ctx.IsGet() && !ctx.IsHead() {
+		ctx.Error("405 Method Not Allowed", fasthttp.StatusMethodNotAllowed)
+		return
+	}
+
+	requestPath := string(ctx.Path())
+	name := strings.TrimPrefix(path.Clean("/"+requestPath), "/")
+	if name == "" {
+		name = "."
+	}
+	if h.dirs[name] {
+		if redirectToDirectory(ctx, requestPath) {
+			return
+		}
+		name = path.Join(name, "index.html")
+	}
+
+	f, ok := h.files[name]
+	if !ok {
+		notFound(ctx)
+		return
+	}
+
+	body, etag := f.body, f.etag
+	for _, v := range f.variants {
+		if ctx.Request.Header.HasAcceptEncoding(v.encoding) {
+			body, etag = v.body, v.etag
+			ctx.Response.Header.Set(fasthttp.HeaderContentEncoding, v.encoding)
+			break
+		}
+	}
+	if len(f.variants) > 0 {
+		ctx.Response.Header.Set(fasthttp.HeaderVary, "Accept-Encoding")
+	}
+
+	etag = `"` + etag + `"`
+	ctx.SetContentType(f.contentType)
+	ctx.Response.Header.Set(fasthttp.HeaderETag, etag)
+	ctx.Response.Header.SetLastModified(f.modTime)
+
+	if etagMatches(ctx.Request.Header.Peek(fasthttp.HeaderIfNoneMatch), etag) {
+		ctx.Response.SkipBody = true
+		ctx.SetStatusCode(fasthttp.StatusNotModified)
+		return
+	}
+	// the bodies are shared between requests and never modified
+	ctx.Response.SetBodyRaw(body)
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/fs"
+	"log"
+	"mime"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// precompressedSuffixes, the digests, precompressed, applyETag and etagMatches are a fork of
+// libs/sdk-ui-web-components/tiny_web_server/static.go, which documents them. The serve image is built
+// from this directory alone, so it cannot import that module; keep fixes in sync. Both are tested with
+// tiny_web_server/testdata/static_choices.json, so the copies choose the same encodings and ETags.
+var precompressedSuffixes = []struct {
+	encoding string
+	suffix   string
+}{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+type fileDigest struct {
+	modTime time.Time
+	size    int64
+	sum     string
+}
+
+// staticHandler serves files confined to root with on-the-fly gzip/brotli compression, prebuilt .br/.gz
+// siblings and strong ETags. All file access goes through an os.Root, so neither ".." nor symlinks
+// can reach outside of it.
+type staticHandler struct {
+	fsys       fs.FS
+	fsHandler  fasthttp.RequestHandler
+	rawHandler fasthttp.RequestHandler
+
+	digests sync.Map // file name -> fileDigest
+}
+
+func newStaticHandler(dir string) (*staticHandler, error) {
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return nil, err
+	}
+	fsys := root.FS()
+
+	h := &staticHandler{fsys: fsys}
+	files := &fasthttp.FS{
+		FS:                 fsys,
+		GenerateIndexPages: false,
+		AcceptByteRange:    true,
+		Compress:           true,
+		CompressBrotli:     true,
+		PathNotFound:       notFound,
+	}
+	// raw serves the prebuilt siblings as they are, they are already encoded
+	raw := &fasthttp.FS{
+		FS:                 fsys,
+		GenerateIndexPages: false,
+		PathNotFound:       notFound,
+	}
+	h.fsHandler = files.NewRequestHandler()
+	h.rawHandler = raw.NewRequestHandler()
+
+	return h, nil
+}
+
+// hasTraversal reports whether the path as sent by the client contains a ".." segment. fasthttp would
+// resolve those silently, but a client asking for them is broken or probing, so it gets a 400.
+func hasTraversal(originalPath []byte) bool {
+	decoded, err := url.PathUnescape(string(originalPath))
+	if err != nil {
+		return true
+	}
+	for _, segment := range strings.FieldsFunc(decoded, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if segment == ".." {
+			return true
+		}
+	}
+	return false
+}
+
+// fileName resolves the request path to a file name relative to root. For directories it returns their
+// index.html and reports whether the request path lacks the trailing slash.
+func (h *staticHandler) fileName(requestPath string) (name string, isDir bool) {
+	name = strings.TrimPrefix(path.Clean("/"+requestPath), "/")
+	if name == "" {
+		name = "."
+	}
+	info, err := fs.Stat(h.fsys, name)
+	if err != nil || !info.IsDir() {
+		return name, false
+	}
+	return path.Join(name, "index.html"), true
+}
+
+func (h *staticHandler) digest(name string) (string, bool) {
+	info, err := fs.Stat(h.fsys, name)
+	if err != nil || info.IsDir() {
+		return "", false
+	}
+	if cached, ok := h.digests.Load(name); ok {
+		d := cached.(fileDigest)
+		if d.modTime.Equal(info.ModTime()) && d.size == info.Size() {
+			return d.sum, true
+		}
+	}
+
+	f, err := h.fsys.Open(name)
+	if err != nil {
+		return "", false
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", false
+	}
+	sum := hex.EncodeToString(hash.Sum(nil))[:32]
+	h.digests.Store(name, fileDigest{modTime: info.ModTime(), size: info.Size(), sum: sum})
+
+	return sum, true
+}
+
+func (h *staticHandler) precompressed(ctx *fasthttp.RequestCtx, name string) (string, string, bool) {
+	original, err := fs.Stat(h.fsys, name)
+	if err != nil {
+		return "", "", false
+	}
+	for _, p := range precompressedSuffixes {
+		if !ctx.Request.Header.HasAcceptEncoding(p.encoding) {
+			continue
+		}
+		sibling, err := fs.Stat(h.fsys, name+p.suffix)
+		if err != nil || sibling.ModTime().Before(original.ModTime()) {
+			continue
+		}
+		return p.encoding, p.suffix, true
+	}
+	return "", "", false
+}
+
+// notFound answers with a plain 404 and logs the miss, a missing asset usually means a broken
+// Storybook build or a stale reference in a story.
+func notFound(ctx *fasthttp.RequestCtx) {
+	if referer := ctx.Request.Header.Referer(); len(referer) > 0 {
+		log.Printf("WARNING: missing asset %s (referenced by %s)", ctx.Path(), referer)
+	} else {
+		log.Printf("WARNING: missing asset %s", ctx.Path())
+	}
+	ctx.Error("404 Not Found", fasthttp.StatusNotFound)
+}
+
+// rejectTraversal answers with 400 when the request path contains "..".
+func rejectTraversal(ctx *fasthttp.RequestCtx) bool {
+	if !hasTraversal(ctx.Request.URI().PathOriginal()) {
+		return false
+	}
+	log.Printf("WARNING: rejected path traversal %q from %s", ctx.Request.URI().PathOriginal(), ctx.RemoteAddr())
+	ctx.Error("400 Bad Request", fasthttp.StatusBadRequest)
+	return true
+}
+
+// redirectToDirectory sends requests for a directory without the trailing slash to the slashed path,
+// relative references in the index only resolve against that.
+func redirectToDirectory(ctx *fasthttp.RequestCtx, requestPath string) bool {
+	if strings.HasSuffix(requestPath, "/") {
+		return false
+	}
+	location := requestPath + "/"
+	if query := ctx.URI().QueryString(); len(query) > 0 {
+		location += "?" + string(query)
+	}
+	ctx.Redirect(location, fasthttp.StatusMovedPermanently)
+	return true
+}
+
+func (h *staticHandler) handle(ctx *fasthttp.RequestCtx) {
+	if rejectTraversal(ctx) {
+		return
+	}
+
+	requestPath := string(ctx.Path())
+	name, isDir := h.fileName(requestPath)
+
+	if isDir {
+		if _, err := fs.Stat(h.fsys, name); err != nil {
+			notFound(ctx)
+			return
+		}
+		if redirectToDirectory(ctx, requestPath) {
+			return
+		}
+	}
+
+	if encoding, suffix, ok := h.precompressed(ctx, name); ok {
+		ctx.Request.Header.Del(fasthttp.HeaderRange)
+		ctx.Request.URI().SetPath("/" + name + suffix)
+		h.rawHandler(ctx)
+		ctx.Request.URI().SetPath(requestPath)
+
+		if ctx.Response.StatusCode() == fasthttp.StatusOK {
+			contentType := mime.TypeByExtension(path.Ext(name))
+			if contentType == "" {
+				contentType = "application/octet-stream"
+			}
+			ctx.SetContentType(contentType)
+			ctx.Response.Header.Set(fasthttp.HeaderContentEncoding, encoding)
+			ctx.Response.Header.Set(fasthttp.HeaderVary, "Accept-Encoding")
+		}
+	} else {
+		// directories are resolved above, fasthttp gets the index file itself
+		ctx.Request.URI().SetPath("/" + name)
+		h.fsHandler(ctx)
+		ctx.Request.URI().SetPath(requestPath)
+	}
+
+	h.applyETag(ctx, name)
+}
+
+func (h *staticHandler) applyETag(ctx *fasthttp.RequestCtx, name string) {
+	status := ctx.Response.StatusCode()
+	if status != fasthttp.StatusOK && status != fasthttp.StatusNotModified {
+		return
+	}
+	sum, ok := h.digest(name)
+	if !ok {
+		return
+	}
+
+	etag := sum
+	if encoding := ctx.Response.Header.ContentEncoding(); len(encoding) > 0 {
+		etag += "-" + string(encoding)
+	}
+	etag = `"` + etag + `"`
+	ctx.Response.Header.Set(fasthttp.HeaderETag, etag)
+
+	if etagMatches(ctx.Request.Header.Peek(fasthttp.HeaderIfNoneMatch), etag) {
+		ctx.Response.ResetBody()
+		ctx.Response.SkipBody = true
+		ctx.Response.Header.Del(fasthttp.HeaderContentRange)
+		ctx.SetStatusCode(fasthttp.StatusNotModified)
+	}
+}
+
+func etagMatches(ifNoneMatch []byte, etag string) bool {
+	if len(ifNoneMatch) == 0 {
+		return false
+	}
+	if bytes.Equal(bytes.TrimSpace(ifNoneMatch), []byte("*")) {
+		return true
+	}
+	for _, candidate := range strings.Split(string(ifNoneMatch), ",") {
+		if strings.TrimPrefix(strings.TrimSpace(candidate), "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestHasTraversal(t *testing.T) {
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{path: "/", want: false},
+		{path: "/iframe.html", want: false},
+		{path: "/assets/..chunk.js", want: false},
+		{path: "/assets/chunk..js", want: false},
+		{path: "/../etc/passwd", want: true},
+		{path: "/assets/../../etc/passwd", want: true},
+		{path: "/..", want: true},
+		{path: "/%2e%2e/etc/passwd", want: true},
+		{path: "/assets/%2E%2E%2Fsecret", want: true},
+		{path: `/assets\..\secret`, want: true},
+		{path: "/%zz", want: true},
+	}
+	for _, tt := range tests {
+		if got := hasTraversal([]byte(tt.path)); got != tt.want {
+			t.Errorf("hasTraversal(%q) = %t, want %t", tt.path, got, tt.want)
+		}
+	}
+}
+
+// staticChoicesPath lists the files and the responses tiny_web_server, which the ETag and precompressed
+// helpers are forked from, is tested with. The fork must answer the same.
+const staticChoicesPath = "../../../sdk-ui-web-components/tiny_web_server/testdata/static_choices.json"
+
+type staticChoices struct {
+	Files    map[string]string `json:"files"`
+	Stale    []string          `json:"stale"`
+	Requests []struct {
+		Path           string `json:"path"`
+		AcceptEncoding string `json:"acceptEncoding"`
+		IfNoneMatch    string `json:"ifNoneMatch"`
+		Status         int    `json:"status"`
+		Encoding       string `json:"encoding"`
+		ETag           string `json:"etag"`
+	} `json:"requests"`
+}
+
+func TestStaticHandlerChoices(t *testing.T) {
+	raw, err := os.ReadFile(staticChoicesPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var choices staticChoices
+	if err := json.Unmarshal(raw, &choices); err != nil {
+		t.Fatal(err)
+	}
+
+	root := t.TempDir()
+	modTime := time.Now()
+	for name, body := range choices.Files {
+		if err := os.WriteFile(filepath.Join(root, name), []byte(body), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(filepath.Join(root, name), modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range choices.Stale {
+		stale := modTime.Add(-time.Hour)
+		if err := os.Chtimes(filepath.Join(root, name), stale, stale); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h, err := newStaticHandler(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, r := range choices.Requests {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.SetRequestURI(r.Path)
+		ctx.Request.Header.Set(fasthttp.HeaderAcceptEncoding, r.AcceptEncoding)
+		ctx.Request.Header.Set(fasthttp.HeaderIfNoneMatch, r.IfNoneMatch)
+		h.handle(ctx)
+
+		resp := &ctx.Response
+		encoding, etag := string(resp.Header.ContentEncoding()), string(resp.Header.Peek(fasthttp.HeaderETag))
+		if resp.StatusCode() != r.Status || encoding != r.Encoding || etag != r.ETag {
+			t.Errorf("%s with Accept-Encoding %q and If-None-Match %q = %d %q %s, want %d %q %s",
+				r.Path, r.AcceptEncoding, r.IfNoneMatch, resp.StatusCode(), encoding, etag, r.Status, r.Encoding, r.ETag)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+// storyEntry is an entry of the Storybook build's index.json, either a story or a docs page.
+type storyEntry struct {
+	Id         string   `json:"id"`
+	Type       string   `json:"type,omitempty"`
+	Title      string   `json:"title"`
+	Name       string   `json:"name"`
+	ImportPath string   `json:"importPath"`
+	Tags       []string `json:"tags,omitempty"`
+}
+
+// storyIndex is what Storybook 7+ writes to index.json.
+type storyIndex struct {
+	Version int                   `json:"v"`
+	Entries map[string]storyEntry `json:"entries"`
+}
+
+func loadStoryIndex(root string) (*storyIndex, error) {
+	indexBytes, err := os.ReadFile(filepath.Join(root, "index.json"))
+	if err != nil {
+		return nil, err
+	}
+	var index storyIndex
+	if err = json.Unmarshal(indexBytes, &index); err != nil {
+		return nil, fmt.Errorf("parse index.json: %w", err)
+	}
+	if index.Entries == nil {
+		return nil, fmt.Errorf("index.json v%d has no entries", index.Version)
+	}
+	return &index, nil
+}
+
+func (i *storyIndex) stories() []storyEntry {
+	stories := make([]storyEntry, 0, len(i.Entries))
+	for _, e := range i.Entries {
+		if e.Type == "" || e.Type == "story" {
+			stories = append(stories, e)
+		}
+	}
+	slices.SortFunc(stories, func(a, b storyEntry) int {
+		return strings.Compare(a.Id, b.Id)
+	})
+	return stories
+}
+
+// handle serves /__stories, the stories of the build sorted by id.
+func (i *storyIndex) handle(ctx *fasthttp.RequestCtx) {
+	stories := i.stories()
+	storiesJSON, err := json.MarshalIndent(struct {
+		Count   int          `json:"count"`
+		Stories []storyEntry `json:"stories"`
+	}{len(stories), stories}, "", "  ")
+	if err != nil {
+		ctx.Error(fmt.Sprintf("stories error: %v", err), fasthttp.StatusInternalServerError)
+		return
+	}
+	ctx.SetContentType("application/json")
+	ctx.SetBody(storiesJSON)
+}
+
+// validateScenarios checks that every iframe.html?id=... target in scenariosPath is a story of the build.
+func (i *storyIndex) validateScenarios(scenariosPath string) (int, error) {
+	scenariosBytes, err := os.ReadFile(scenariosPath)
+	if err != nil {
+		return 0, err
+	}
+	var scenarios []struct {
+		Label string `json:"label"`
+		Url   string `json:"url"`
+	}
+	if err = json.Unmarshal(scenariosBytes, &scenarios); err != nil {
+		return 0, fmt.Errorf("parse %s: %w", scenariosPath, err)
+	}
+
+	var missing []string
+	checked := 0
+	for _, s := range scenarios {
+		u, err := url.Parse(s.Url)
+		if err != nil {
+			missing = append(missing, fmt.Sprintf("%q: invalid url %q", s.Label, s.Url))
+			continue
+		}
+		if path.Base(u.Path) != "iframe.html" {
+			continue
+		}
+		checked++
+		id := u.Query().Get("id")
+		if entry, ok := i.Entries[id]; !ok || (entry.Type != "" && entry.Type != "story") {
+			missing = append(missing, fmt.Sprintf("%q: story %q not found", s.Label, id))
+		}
+	}
+	if len(missing) > 0 {
+		return checked, fmt.Errorf("%d of %d scenarios point at missing stories:\n  %s", len(missing), checked, strings.Join(missing, "\n  "))
+	}
+	return checked, nil
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	// scenarioHeader and scenarioParam carry the scenario a request belongs to. The test runner adds the
+	// param to the iframe.html URL, the assets the page loads are attributed through their Referer.
+	// Module imports, lazy chunks and CSS url() send the module or stylesheet as the Referer, they are
+	// attributed to the scenario whose page loaded that URL.
+	scenarioHeader = "X-Neobackstop-Scenario"
+	scenarioParam  = "neobackstopScenario"
+
+	// maxTracedRequests bounds the log and the slow requests of a single scenario, the runner tags every
+	// attempt on its own
+	maxTracedRequests = 1000
+
+	// ownerWindow is how long a URL stays attributed to the page that loaded it. When pages of other
+	// scenarios load it within the window, the requests it makes are not attributed to any of them.
+	ownerWindow = time.Minute
+)
+
+type tracedRequest struct {
+	Time       time.Time `json:"time"`
+	Method     string    `json:"method"`
+	Path       string    `json:"path"`
+	Status     int       `json:"status"`
+	Bytes      int       `json:"bytes"`
+	DurationMs float64   `json:"durationMs"`
+}
+
+// urlOwner is the scenario whose page loaded a URL last, empty when pages of several scenarios loaded
+// it within ownerWindow.
+type urlOwner struct {
+	scenario string
+	at       time.Time
+}
+
+type scenarioTrace struct {
+	Scenario string          `json:"scenario"`
+	Requests []tracedRequest `json:"requests"`
+	NotFound []string        `json:"notFound"`
+	Slow     []tracedRequest `json:"slow"`
+	Dropped  int             `json:"dropped,omitempty"`
+}
+
+// tracer keeps a request log per scenario, so the test runner can explain a failed screenshot with the
+// assets that were missing or slow while it was captured.
+type tracer struct {
+	slow time.Duration
+
+	mu     sync.Mutex
+	traces map[string]*scenarioTrace
+	owners map[string]urlOwner
+}
+
+func newTracer(slow time.Duration) *tracer {
+	return &tracer{slow: slow, traces: map[string]*scenarioTrace{}, owners: map[string]urlOwner{}}
+}
+
+// scenarioOf returns the scenario id of the request from the header, the query param, the param of the
+// Referer or the owner of the Referer, in that order.
+func (t *tracer) scenarioOf(ctx *fasthttp.RequestCtx) string {
+	if id := ctx.Request.Header.Peek(scenarioHeader); len(id) > 0 {
+		return string(id)
+	}
+	if id := ctx.QueryArgs().Peek(scenarioParam); len(id) > 0 {
+		return string(id)
+	}
+	referer := ctx.Request.Header.Referer()
+	if len(referer) == 0 {
+		return ""
+	}
+	if u, err := url.Parse(string(referer)); err == nil {
+		if id := u.Query().Get(scenarioParam); id != "" {
+			return id
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if owner, ok := t.owners[string(referer)]; ok && time.Since(owner.at) < ownerWindow {
+		return owner.scenario
+	}
+	return ""
+}
+
+// own records that the page of scenario loaded the URL. Another scenario takes it over only after
+// ownerWindow, before that the URL has no owner.
+func (t *tracer) own(uri string, scenario string, at time.Time) {
+	owner, ok := t.owners[uri]
+	if ok && owner.scenario != scenario && at.Sub(owner.at) < ownerWindow {
+		owner.scenario = ""
+	} else {
+		owner.scenario = scenario
+	}
+	owner.at = at
+	t.owners[uri] = owner
+}
+
+func (t *tracer) wrap(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		if string(ctx.Path()) == "/__trace" {
+			t.handle(ctx)
+			return
+		}
+
+		scenario := t.scenarioOf(ctx)
+		if scenario == "" {
+			next(ctx)
+			return
+		}
+
+		t0 := time.Now()
+		next(ctx)
+		// a page of another scenario must request the URL again instead of taking it from the cache, so
+		// the requests the URL makes from that page are not attributed to this scenario
+		if len(ctx.Response.Header.Peek(fasthttp.HeaderCacheControl)) == 0 {
+			ctx.Response.Header.Set(fasthttp.HeaderCacheControl, "no-cache")
+		}
+		size := ctx.Response.Header.ContentLength()
+		if size < 0 || (size == 0 && !ctx.Response.IsBodyStream()) {
+			size = len(ctx.Response.Body())
+		}
+		t.record(scenario, ctx.URI().String(), tracedRequest{
+			Time:       t0,
+			Method:     string(ctx.Method()),
+			Path:       string(ctx.Path()),
+			Status:     ctx.Response.StatusCode(),
+			Bytes:      size,
+			DurationMs: float64(time.Since(t0).Microseconds()) / 1000,
+		})
+	}
+}
+
+// record adds the request of uri to the trace of scenario. The requests past maxTracedRequests are only
+// counted, a missing asset is still listed.
+func (t *tracer) record(scenario string, uri string, r tracedRequest) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.own(uri, scenario, r.Time)
+
+	trace, ok := t.traces[scenario]
+	if !ok {
+		trace = &scenarioTrace{Scenario: scenario, Requests: []tracedRequest{}, NotFound: []string{}, Slow: []tracedRequest{}}
+		t.traces[scenario] = trace
+	}
+	if len(trace.Requests) < maxTracedRequests {
+		trace.Requests = append(trace.Requests, r)
+		if t.slow > 0 && r.DurationMs >= float64(t.slow.Microseconds())/1000 {
+			trace.Slow = append(trace.Slow, r)
+		}
+	} else {
+		trace.Dropped++
+	}
+	if r.Status == fasthttp.StatusNotFound && !slices.Contains(trace.NotFound, r.Path) {
+		trace.NotFound = append(trace.NotFound, r.Path)
+	}
+}
+
+// handle serves /__trace?scenario=<id> with the log of one scenario and /__trace with the list of
+// traced scenarios.
+func (t *tracer) handle(ctx *fasthttp.RequestCtx) {
+	t.mu.Lock()
+	var body any
+	if scenario := string(ctx.QueryArgs().Peek("scenario")); scenario != "" {
+		trace, ok := t.traces[scenario]
+		if !ok {
+			t.mu.Unlock()
+			ctx.Error(fmt.Sprintf("no requests traced for scenario %q", scenario), fasthttp.StatusNotFound)
+			return
+		}
+		body = trace
+	} else {
+		body = slices.Sorted(maps.Keys(t.traces))
+	}
+	traceJSON, err := json.MarshalIndent(body, "", "  ")
+	t.mu.Unlock()
+
+	if err != nil {
+		ctx.Error(fmt.Sprintf("trace error: %v", err), fasthttp.StatusInternalServerError)
+		return
+	}
+	ctx.SetContentType("application/json")
+	ctx.SetBody(traceJSON)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+func tracedHandler(tr *tracer) func(uri, referer string) *fasthttp.RequestCtx {
+	handler := tr.wrap(func(ctx *fasthttp.RequestCtx) {
+		if string(ctx.Path()) == "/assets/missing.js" {
+			ctx.SetStatusCode(fasthttp.StatusNotFound)
+			return
+		}
+		ctx.SetBodyString("ok")
+	})
+	return func(uri, referer string) *fasthttp.RequestCtx {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.SetRequestURI(uri)
+		if referer != "" {
+			ctx.Request.Header.Set(fasthttp.HeaderReferer, referer)
+		}
+		handler(ctx)
+		return ctx
+	}
+}
+
+func tracedPaths(tr *tracer, scenario string) []string {
+	var paths []string
+	if trace, ok := tr.traces[scenario]; ok {
+		for _, r := range trace.Requests {
+			paths = append(paths, r.Path)
+		}
+	}
+	return paths
+}
+
+func TestTracerAttributesNestedImports(t *testing.T) {
+	tr := newTracer(0)
+	serve := tracedHandler(tr)
+
+	const page = "http://localhost/iframe.html?id=button--primary&neobackstopScenario=button--primary_chromium_desktop"
+	serve(page, "")
+	// the entry module is attributed through the Referer of the page
+	entry := serve("http://localhost/assets/entry.js", page)
+	if got := string(entry.Response.Header.Peek(fasthttp.HeaderCacheControl)); got != "no-cache" {
+		t.Errorf("Cache-Control = %q, want no-cache for an attributed response", got)
+	}
+	// its import and the lazy chunk it loads only have the module as the Referer
+	serve("http://localhost/assets/button.js", "http://localhost/assets/entry.js")
+	serve("http://localhost/assets/missing.js", "http://localhost/assets/button.js")
+	// a module no traced page loaded is not attributed
+	serve("http://localhost/assets/other.js", "http://localhost/assets/unknown.js")
+
+	trace, ok := tr.traces["button--primary_chromium_desktop"]
+	if !ok {
+		t.Fatalf("no trace, traced %v", tr.traces)
+	}
+	want := []string{"/iframe.html", "/assets/entry.js", "/assets/button.js", "/assets/missing.js"}
+	if got := tracedPaths(tr, trace.Scenario); !slices.Equal(got, want) {
+		t.Fatalf("traced %v, want %v", got, want)
+	}
+	if len(trace.NotFound) != 1 || trace.NotFound[0] != "/assets/missing.js" {
+		t.Errorf("not found %v, want the nested import", trace.NotFound)
+	}
+	if len(tr.traces) != 1 {
+		t.Errorf("traced scenarios %v, want one", tr.traces)
+	}
+}
+
+func TestTracerKeepsConcurrentPagesApart(t *testing.T) {
+	tr := newTracer(0)
+	serve := tracedHandler(tr)
+
+	const (
+		first  = "http://localhost/iframe.html?id=a&neobackstopScenario=a_chromium_desktop"
+		second = "http://localhost/iframe.html?id=b&neobackstopScenario=b_chromium_desktop"
+	)
+	serve(first, "")
+	serve(second, "")
+	serve("http://localhost/assets/entry.js", first)
+	serve("http://localhost/assets/entry.js", second)
+	// both pages loaded the entry module, its import can belong to either of them
+	serve("http://localhost/assets/button.js", "http://localhost/assets/entry.js")
+
+	if got, want := tracedPaths(tr, "a_chromium_desktop"), []string{"/iframe.html", "/assets/entry.js"}; !slices.Equal(got, want) {
+		t.Errorf("first page traced %v, want %v", got, want)
+	}
+	if got, want := tracedPaths(tr, "b_chromium_desktop"), []string{"/iframe.html", "/assets/entry.js"}; !slices.Equal(got, want) {
+		t.Errorf("second page traced %v, want %v", got, want)
+	}
+
+	// after ownerWindow the entry module belongs to the page that loads it next
+	owner := tr.owners["http://localhost/assets/entry.js"]
+	owner.at = owner.at.Add(-ownerWindow)
+	tr.owners["http://localhost/assets/entry.js"] = owner
+	serve("http://localhost/assets/entry.js", second)
+	serve("http://localhost/assets/button.js", "http://localhost/assets/entry.js")
+	if got, want := tracedPaths(tr, "b_chromium_desktop"), []string{"/iframe.html", "/assets/entry.js", "/assets/entry.js", "/assets/button.js"}; !slices.Equal(got, want) {
+		t.Errorf("second page traced %v, want %v", got, want)
+	}
+}
+
+func TestTracerBoundsSlowRequests(t *testing.T) {
+	tr := newTracer(time.Millisecond)
+	for i := 0; i <= maxTracedRequests; i++ {
+		tr.record("a_chromium_desktop", "http://localhost/assets/slow.js", tracedRequest{Time: time.Now(), Path: "/assets/slow.js", Status: fasthttp.StatusOK, DurationMs: 5})
+	}
+
+	trace := tr.traces["a_chromium_desktop"]
+	if len(trace.Requests) != maxTracedRequests || len(trace.Slow) != maxTracedRequests || trace.Dropped != 1 {
+		t.Errorf("traced %d requests, %d slow, %d dropped", len(trace.Requests), len(trace.Slow), trace.Dropped)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+// virtualHost is an entry of the --vhosts file:
+//
+//	[{"host": "ag-grid.com", "root": "dist", "headers": {"Cache-Control": "no-store"}}]
+//
+// A relative root is resolved against the directory of the file, an empty root means --root.
+type virtualHost struct {
+	Host    string            `json:"host"`
+	Root    string            `json:"root"`
+	Headers map[string]string `json:"headers"`
+}
+
+func loadVirtualHosts(vhostsPath string) ([]virtualHost, error) {
+	vhostsBytes, err := os.ReadFile(vhostsPath)
+	if err != nil {
+		return nil, err
+	}
+	var vhosts []virtualHost
+	if err = json.Unmarshal(vhostsBytes, &vhosts); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", vhostsPath, err)
+	}
+
+	seen := map[string]bool{}
+	for i, v := range vhosts {
+		host := strings.ToLower(v.Host)
+		if host == "" || strings.Contains(host, ":") {
+			return nil, fmt.Errorf("%s: entry %d: host must be a bare hostname, got %q", vhostsPath, i, v.Host)
+		}
+		if seen[host] {
+			return nil, fmt.Errorf("%s: duplicate host %q", vhostsPath, host)
+		}
+		seen[host] = true
+		vhosts[i].Host = host
+
+		if v.Root != "" {
+			root := v.Root
+			if !filepath.IsAbs(root) {
+				root = filepath.Join(filepath.Dir(vhostsPath), root)
+			}
+			// absolute like --root, so a vhost of the same directory shares its handler
+			if vhosts[i].Root, err = filepath.Abs(root); err != nil {
+				return nil, fmt.Errorf("%s: entry %d: %w", vhostsPath, i, err)
+			}
+		}
+	}
+	return vhosts, nil
+}
+
+type hostHandler struct {
+	handler fasthttp.RequestHandler
+	headers map[string]string
+}
+
+// hostRouter dispatches requests by the Host header, requests for other hosts go to fallback (--root).
+type hostRouter struct {
+	hosts    map[string]hostHandler
+	fallback fasthttp.RequestHandler
+}
+
+func (r *hostRouter) handle(ctx *fasthttp.RequestCtx) {
+	host := strings.ToLower(string(ctx.Host()))
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	h, ok := r.hosts[host]
+	if !ok {
+		r.fallback(ctx)
+		return
+	}
+
+	h.handler(ctx)
+	for key, value := range h.headers {
+		ctx.Response.Header.Set(key, value)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadVirtualHostsResolvesRoots(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "vhosts.json"), []byte(`[
+		{"host": "AG-Grid.com", "root": "dist"},
+		{"host": "fixtures.local", "root": "../fixtures"},
+		{"host": "default.local"}
+	]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Chdir(dir)
+
+	// a relative --vhosts path must give the same roots as main.go resolves --root to
+	vhosts, err := loadVirtualHosts("vhosts.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	absDir, err := filepath.Abs(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []virtualHost{
+		{Host: "ag-grid.com", Root: filepath.Join(absDir, "dist")},
+		{Host: "fixtures.local", Root: filepath.Join(filepath.Dir(absDir), "fixtures")},
+		{Host: "default.local"},
+	}
+	for i, v := range vhosts {
+		if v.Host != want[i].Host || v.Root != want[i].Root {
+			t.Errorf("entry %d = %+v, want %+v", i, v, want[i])
+		}
+	}
+}
+
+func TestLoadVirtualHostsRejectsInvalidHosts(t *testing.T) {
+	for _, content := range []string{
+		`[{"host": ""}]`,
+		`[{"host": "ag-grid.com:8080"}]`,
+		`[{"host": "ag-grid.com"}, {"host": "AG-GRID.COM"}]`,
+	} {
+		path := filepath.Join(t.TempDir(), "vhosts.json")
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loadVirtualHosts(path); err == nil {
+			t.Errorf("%s accepted", content)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/gooddata/gooddata-neobackstop/config"
+)
+
+func runApprove(args []string) int {
+	fs := newFlagSet("approve", "Captures the selected scenarios into bitmapsReferencePath, replacing their references.\n\nWith --from-results nothing is captured, the test screenshots of the failed scenarios of the last\ntest run are copied over their references instead. --dry-run prints their ids one per line, the list\ncan be reviewed, trimmed and passed back with --ids-file.")
+	var opts options
+	var hosts hostMappings
+	registerConfigFlag(fs, &opts)
+	registerScenariosFlag(fs, &opts)
+	registerHostMapFlag(fs, &hosts)
+	filter := registerFilterFlags(fs)
+	fromResults := fs.Bool("from-results", false, "promote the test screenshots of failed scenarios from results.json instead of capturing")
+	resultsPath := fs.String("results", "", "results.json read by --from-results (default <ciReportPath>/results.json)")
+	dryRun := fs.Bool("dry-run", false, "with --from-results, only print what would be approved")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+
+	if *fromResults {
+		return approveFromResults(opts, *resultsPath, filter, *dryRun)
+	}
+	if *resultsPath != "" || *dryRun {
+		return fail(errors.New("--results and --dry-run require --from-results"))
+	}
+
+	startMemoryStats()
+
+	configuration, internalScenarios, err := loadInternalScenarios(opts, &hosts, false)
+	if err != nil {
+		return fail(err)
+	}
+	if internalScenarios, err = selectScenarios(configuration, internalScenarios, filter); err != nil {
+		return fail(err)
+	}
+
+	pw, err := startPlaywright(configuration)
+	if err != nil {
+		return fail(err)
+	}
+	defer stopPlaywright(pw)
+
+	// we don't need to generate diffs, the captures are the new references
+	if _, _, err = capture(pw, "approve", configuration.BitmapsReferencePath, configuration, internalScenarios); err != nil {
+		return fail(err)
+	}
+	return 0
+}
+
+// approval is a test screenshot to be copied over its reference.
+type approval struct {
+	result    scenarioResult
+	fileName  string
+	reference bool // false when the scenario has no reference yet
+}
+
+func (a approval) change() string {
+	if !a.reference {
+		return "new reference"
+	}
+	if a.result.MisMatchPercentage != nil {
+		return fmt.Sprintf("updated, %.2f%% mismatch", *a.result.MisMatchPercentage)
+	}
+	return "updated"
+}
+
+// approveFromResults copies the test screenshots of the failed results selected by the filter over the
+// references.
+func approveFromResults(opts options, resultsPath string, filter *scenarioFilter, dryRun bool) int {
+	configuration, err := loadConfig(opts.configPath)
+	if err != nil {
+		return fail(err)
+	}
+	if resultsPath == "" {
+		resultsPath = filepath.Join(configuration.CiReportPath, resultsFileName)
+	}
+	results, err := readResults(resultsPath)
+	if err != nil {
+		return fail(err)
+	}
+	if err = filter.prepare(configuration); err != nil {
+		return fail(err)
+	}
+
+	approvals, skipped := selectApprovals(configuration, results, filter)
+	for _, r := range skipped {
+		reason := "no test screenshot"
+		if r.Error != nil {
+			reason = *r.Error
+		}
+		fmt.Fprintf(os.Stderr, "Skipping %s: %s\n", r.Scenario.Id, reason)
+	}
+	if len(approvals) == 0 {
+		fmt.Fprintln(os.Stderr, "Nothing to approve in", resultsPath)
+		return 0
+	}
+
+	if dryRun {
+		// ids go to stdout so the output can be used as --ids-file
+		for _, a := range approvals {
+			fmt.Println(a.result.Scenario.Id)
+			fmt.Fprintf(os.Stderr, "Would approve %s (%s): %s\n", a.fileName, a.result.Scenario.Label, a.change())
+		}
+		fmt.Fprintf(os.Stderr, "Would approve %d screenshots, skip %d\n", len(approvals), len(skipped))
+		return 0
+	}
+
+	if err = os.MkdirAll(configuration.BitmapsReferencePath, 0777); err != nil {
+		return fail(err)
+	}
+	added := 0
+	for _, a := range approvals {
+		err = copyFile(filepath.Join(configuration.BitmapsTestPath, a.fileName), filepath.Join(configuration.BitmapsReferencePath, a.fileName))
+		if err != nil {
+			return fail(fmt.Errorf("approve %s: %w", a.result.Scenario.Id, err))
+		}
+		if !a.reference {
+			added++
+		}
+		fmt.Printf("Approved %s (%s): %s\n", a.fileName, a.result.Scenario.Label, a.change())
+	}
+	fmt.Printf("Approved %d screenshots: %d updated, %d new, %d skipped\n", len(approvals), len(approvals)-added, added, len(skipped))
+	return 0
+}
+
+// selectApprovals returns the failed results selected by the filter that have a test screenshot, and
+// the ones that cannot be approved because the capture failed.
+func selectApprovals(configuration config.Config, results []scenarioResult, filter *scenarioFilter) ([]approval, []scenarioResult) {
+	var approvals []approval
+	var skipped []scenarioResult
+	for _, r := range results {
+		// new results can be approved as well. Flaky ones cannot, their screenshot is from a failed attempt
+		// while a later one matched the reference.
+		if r.Status == statusFlaky || !r.Status.fails(runnerConfig{NewFails: true}) || !filter.matches(r.Scenario) {
+			continue
+		}
+		if r.ScreenshotFileName == nil {
+			skipped = append(skipped, r)
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(configuration.BitmapsTestPath, *r.ScreenshotFileName)); err != nil {
+			skipped = append(skipped, r)
+			continue
+		}
+		_, err := os.Stat(filepath.Join(configuration.BitmapsReferencePath, *r.ScreenshotFileName))
+		approvals = append(approvals, approval{result: r, fileName: *r.ScreenshotFileName, reference: err == nil})
+	}
+	return approvals, skipped
+}
+
+// copyFile replaces dst with a copy of src, through a temporary file so an interrupted copy never leaves
+// a truncated reference.
+func copyFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.CreateTemp(filepath.Dir(dst), ".copy-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(out.Name())
+
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err = out.Close(); err != nil {
+		return err
+	}
+	if err = os.Chmod(out.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(out.Name(), dst)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gooddata/gooddata-neobackstop/config"
+)
+
+func TestSelectApprovals(t *testing.T) {
+	dir := t.TempDir()
+	configuration := config.Config{
+		BitmapsTestPath:      filepath.Join(dir, "test"),
+		BitmapsReferencePath: filepath.Join(dir, "reference"),
+	}
+	failing := reportResult("Kind - failing", statusFail)
+	added := reportResult("Kind - added", statusNew)
+	flaky := reportResult("Kind - flaky", statusFlaky)
+	flaky.ReferenceFileName = flaky.ScreenshotFileName
+	flaky.Attempts = attempts(statusFail, statusPass)
+	passing := reportResult("Kind - passing", statusPass)
+	broken := reportResult("Kind - broken", statusError)
+
+	for _, path := range []string{configuration.BitmapsTestPath, configuration.BitmapsReferencePath} {
+		if err := os.Mkdir(path, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, r := range []scenarioResult{failing, added, flaky, passing} {
+		if err := os.WriteFile(filepath.Join(configuration.BitmapsTestPath, *r.ScreenshotFileName), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, r := range []scenarioResult{failing, flaky} {
+		if err := os.WriteFile(filepath.Join(configuration.BitmapsReferencePath, *r.ScreenshotFileName), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	approvals, skipped := selectApprovals(configuration, []scenarioResult{failing, added, flaky, passing, broken}, &scenarioFilter{})
+	if len(approvals) != 2 || approvals[0].fileName != "Kind - failing.png" || !approvals[0].reference || approvals[1].fileName != "Kind - added.png" || approvals[1].reference {
+		t.Errorf("approvals = %+v, want the failing and the new scenario", approvals)
+	}
+	// a flaky result is neither approved nor reported as skipped, a later attempt matched its reference
+	if len(skipped) != 1 || skipped[0].Scenario.Id != "Kind - broken" {
+		t.Errorf("skipped = %+v, want the errored scenario", skipped)
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"runtime"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gooddata/gooddata-neobackstop/comparer"
+	"github.com/gooddata/gooddata-neobackstop/config"
+	"github.com/gooddata/gooddata-neobackstop/internals"
+	"github.com/gooddata/gooddata-neobackstop/screenshotter"
+
+	"github.com/playwright-community/playwright-go"
+)
+
+func bToMb(b uint64) uint64 {
+	return b / 1024 / 1024
+}
+
+func startMemoryStats() {
+	go (func() {
+		for {
+			var m runtime.MemStats
+			runtime.ReadMemStats(&m)
+			fmt.Printf("MEMORY STATS | Alloc = %v MiB", bToMb(m.Alloc))
+			fmt.Printf("\tTotalAlloc = %v MiB", bToMb(m.TotalAlloc))
+			fmt.Printf("\tSys = %v MiB", bToMb(m.Sys))
+			fmt.Printf("\tNumGC = %v\n", m.NumGC)
+			time.Sleep(2 * time.Second)
+		}
+	})()
+
+	//go (func() {
+	//	for {
+	//		procs, err := process.Processes()
+	//		if err != nil {
+	//			log.Fatalf("Error listing processes: %v", err)
+	//		}
+	//
+	//		var totalCPU float64
+	//		var totalMemMB float64
+	//
+	//		for _, p := range procs {
+	//			name, err := p.Name()
+	//			if err != nil || name != "headless_shell" {
+	//				continue
+	//			}
+	//
+	//			cpuPercent, err := p.CPUPercent()
+	//			if err == nil {
+	//				totalCPU += cpuPercent
+	//			}
+	//
+	//			memInfo, err := p.MemoryInfo()
+	//			if err == nil {
+	//				totalMemMB += float64(memInfo.RSS) / 1024.0 / 1024.0
+	//			}
+	//		}
+	//
+	//		numCores := runtime.NumCPU()
+	//		cpuPercentNormalized := totalCPU / float64(numCores)
+	//
+	//		fmt.Printf("CHROMIUM USAGE STATS | CPU %.2f%% (normalized %.2f%%), Memory %.2f MB\n",
+	//			totalCPU, cpuPercentNormalized, totalMemMB)
+	//
+	//		time.Sleep(2 * time.Second)
+	//	}
+	//})()
+}
+
+// browserNames returns the unique playwright browsers behind the aliases in the configuration.
+func browserNames(configuration config.Config) []string {
+	browsers := map[string]interface{}{}
+	for _, b := range configuration.Browsers {
+		// convert to string because playwright.Install requires a slice of strings
+		browsers[string(b.Name)] = nil
+	}
+	return slices.Sorted(maps.Keys(browsers))
+}
+
+// jobTimer measures the jobs of a worker pool by key. The jobs channels are unbuffered, so a send
+// returns when a worker picks the job up. A job can finish before its sender records that, so the start
+// is first recorded before the send and moved to the pick up only while the job is still running.
+type jobTimer struct {
+	mu        sync.Mutex
+	started   map[string]time.Time
+	durations map[string]time.Duration
+}
+
+func newJobTimer(size int) *jobTimer {
+	return &jobTimer{started: make(map[string]time.Time, size), durations: make(map[string]time.Duration, size)}
+}
+
+func (t *jobTimer) sending(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.started[key] = time.Now()
+}
+
+func (t *jobTimer) picked(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, done := t.durations[key]; !done {
+		t.started[key] = time.Now()
+	}
+}
+
+func (t *jobTimer) finished(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if start, ok := t.started[key]; ok {
+		t.durations[key] = time.Since(start)
+	}
+}
+
+// startPlaywright installs the drivers of the configured browsers and starts playwright once for all the
+// captures of a command, stopPlaywright stops it.
+func startPlaywright(configuration config.Config) (*playwright.Playwright, error) {
+	// download drivers
+	if err := playwright.Install(&playwright.RunOptions{
+		Browsers: browserNames(configuration),
+	}); err != nil {
+		return nil, fmt.Errorf("could not install playwright drivers: %w", err)
+	}
+
+	// run playwright
+	pw, err := playwright.Run()
+	if err != nil {
+		return nil, fmt.Errorf("could not start playwright: %w", err)
+	}
+	return pw, nil
+}
+
+func stopPlaywright(pw *playwright.Playwright) {
+	if err := pw.Stop(); err != nil {
+		fmt.Println("WARNING: could not stop playwright:", err)
+	}
+}
+
+// capture takes the screenshots of internalScenarios into saveDir with AsyncCaptureLimit workers. It
+// also returns how long each internal scenario took by scenarioKey, from a worker picking it up to its
+// result.
+func capture(pw *playwright.Playwright, mode string, saveDir string, configuration config.Config, internalScenarios []internals.Scenario) ([]screenshotter.Result, map[string]time.Duration, error) {
+	var err error
+	if _, err = os.Stat(saveDir); os.IsNotExist(err) {
+		// saveDir does not exist
+		if err = os.Mkdir(saveDir, 0777); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	numInternalScenarios := len(internalScenarios)
+
+	// create the channel and wait group, the jobs channel is unbuffered for the jobTimer
+	screenshotterJobs := make(chan internals.Scenario)
+	screenshotterResults := make(chan screenshotter.Result, numInternalScenarios)
+	var wg sync.WaitGroup
+
+	for w := 1; w <= configuration.AsyncCaptureLimit; w++ {
+		wg.Add(1)
+		go screenshotter.Run(saveDir, pw, configuration, screenshotterJobs, &wg, screenshotterResults, w, mode)
+	}
+
+	timer := newJobTimer(numInternalScenarios)
+
+	// send jobs and close
+	go (func() {
+		for _, s := range internalScenarios {
+			timer.sending(scenarioKey(s))
+			screenshotterJobs <- s
+			timer.picked(scenarioKey(s))
+		}
+
+		close(screenshotterJobs)
+	})()
+
+	t0 := time.Now()
+
+	go (func() {
+		wg.Wait()
+
+		close(screenshotterResults)
+	})()
+
+	results := make([]screenshotter.Result, 0, numInternalScenarios)
+	for r := range screenshotterResults {
+		timer.finished(scenarioKey(*r.Scenario))
+		results = append(results, r)
+	}
+
+	fmt.Println("Screenshotter took", time.Now().Sub(t0).String())
+
+	return results, timer.durations, nil
+}
+
+// compare compares successful captures with their references with AsyncCompareLimit workers. It also
+// returns how long each comparison took by scenarioKey.
+func compare(configuration config.Config, successfulCaptures []screenshotter.Result) ([]comparer.Result, map[string]time.Duration) {
+	numSuccessfulCaptures := len(successfulCaptures)
+	fmt.Println("Comparing", numSuccessfulCaptures, "screenshots")
+
+	// unbuffered like in capture, to time the jobs
+	compareJobs := make(chan screenshotter.Result)
+	compareResults := make(chan comparer.Result, numSuccessfulCaptures)
+	var wg sync.WaitGroup
+
+	for w := 1; w <= configuration.AsyncCompareLimit; w++ {
+		wg.Add(1)
+		go comparer.Run(configuration, compareJobs, &wg, compareResults, w)
+	}
+
+	timer := newJobTimer(numSuccessfulCaptures)
+
+	// send jobs and close
+	go (func() {
+		for _, s := range successfulCaptures {
+			timer.sending(scenarioKey(*s.Scenario))
+			compareJobs <- s
+			timer.picked(scenarioKey(*s.Scenario))
+		}
+
+		close(compareJobs)
+	})()
+
+	t1 := time.Now()
+
+	go (func() {
+		wg.Wait()
+
+		close(compareResults)
+	})()
+
+	results := make([]comparer.Result, 0, numSuccessfulCaptures)
+	for r := range compareResults {
+		timer.finished(scenarioKey(*r.ScreenshotterResult.Scenario))
+		results = append(results, r)
+	}
+
+	fmt.Println("Comparer took", time.Now().Sub(t1).String())
+
+	return results, timer.durations
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gooddata/gooddata-neobackstop/config"
+	"github.com/gooddata/gooddata-neobackstop/converters"
+	"github.com/gooddata/gooddata-neobackstop/internals"
+	"github.com/gooddata/gooddata-neobackstop/screenshotter"
+)
+
+func registerHostMapFlag(fs *flag.FlagSet, hosts *hostMappings) {
+	fs.Var(hosts, "host-map", "HOST=TARGET, resolve HOST in scenario urls to TARGET in chromium, repeatable (also NEOBACKSTOP_HOST_MAP)")
+}
+
+// loadInternalScenarios reads the configuration and the scenarios and expands the scenarios to one
+// internal scenario per browser and viewport. With runnerRetries neobackstop's retries are off, the
+// caller retries itself with recapture and records every attempt.
+func loadInternalScenarios(opts options, hosts *hostMappings, runnerRetries bool) (config.Config, []internals.Scenario, error) {
+	// read config
+	configuration, err := loadConfig(opts.configPath)
+	if err != nil {
+		return configuration, nil, err
+	}
+
+	if err = hosts.addEnv(); err != nil {
+		return configuration, nil, err
+	}
+	applyHostMappings(&configuration, *hosts)
+
+	configurationJson, err := json.MarshalIndent(configuration, "", "  ")
+	if err != nil {
+		return configuration, nil, err
+	}
+
+	fmt.Println("Received configuration:", string(configurationJson))
+
+	// read scenarios
+	scenarios, err := loadScenarios(opts.scenariosPath)
+	if err != nil {
+		return configuration, nil, err
+	}
+
+	fmt.Println("Received", len(scenarios), "scenarios")
+
+	// build internal scenarios
+	retryCount := configuration.RetryCount
+	if runnerRetries {
+		retryCount = 0
+	}
+	internalScenarios := converters.ScenariosToInternal(configuration.DefaultBrowsers, configuration.Viewports, retryCount, scenarios)
+
+	fmt.Println("Generated", len(internalScenarios), "internal scenarios")
+
+	return configuration, internalScenarios, nil
+}
+
+func runTest(args []string) int {
+	fs := newFlagSet("test", "Captures the selected scenarios into bitmapsTestPath, compares them with the references and writes\nresults.json and the HTML report. Exits with 1 when a scenario fails.")
+	var opts options
+	var hosts hostMappings
+	registerConfigFlag(fs, &opts)
+	registerScenariosFlag(fs, &opts)
+	registerHostMapFlag(fs, &hosts)
+	filter := registerFilterFlags(fs)
+	var currentShard shard
+	fs.Var(&currentShard, "shard", "i/n, capture only the i-th of n parts of the selected scenarios, combine the parts with merge")
+	reportOpts := registerReportFlags(fs)
+	reverifyTimes := fs.Int("reverify", 0, "capture failed scenarios this many more times after the retries and classify them as deterministic or flaky")
+	timingsPath := fs.String("timings", "", "results.json of a previous run to balance the shards by capture duration, all shards must use the same file")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if err := reportOpts.validate(); err != nil {
+		return fail(err)
+	}
+	if *reverifyTimes < 0 {
+		return fail(errors.New("--reverify must not be negative"))
+	}
+	if *timingsPath != "" && !currentShard.active() {
+		return fail(errors.New("--timings requires --shard"))
+	}
+
+	startMemoryStats()
+
+	configuration, internalScenarios, err := loadInternalScenarios(opts, &hosts, true)
+	if err != nil {
+		return fail(err)
+	}
+	if err = reportOpts.load(opts.configPath); err != nil {
+		return fail(err)
+	}
+
+	// before selecting, references of deselected scenarios are not orphans
+	warnOrphanedReferences(configuration, internalScenarios)
+
+	if internalScenarios, err = selectScenarios(configuration, internalScenarios, filter); err != nil {
+		return fail(err)
+	}
+
+	if currentShard.active() {
+		var timings map[string]time.Duration
+		if *timingsPath != "" {
+			if timings, err = loadTimings(*timingsPath); err != nil {
+				return fail(err)
+			}
+		}
+		internalScenarios = currentShard.apply(internalScenarios, timings)
+		fmt.Println("Shard", currentShard.String(), "has", len(internalScenarios), "internal scenarios")
+	}
+
+	// tag the urls, so failed scenarios can be explained with what serve saw while capturing them
+	originalUrls := make(map[string]string, len(internalScenarios))
+	for i := range internalScenarios {
+		key := scenarioKey(internalScenarios[i])
+		originalUrls[key] = internalScenarios[i].Url
+		internalScenarios[i].Url = tagScenarioUrl(internalScenarios[i].Url, key)
+	}
+
+	pw, err := startPlaywright(configuration)
+	if err != nil {
+		return fail(err)
+	}
+	// the retries and re-verifications reuse the browsers
+	defer stopPlaywright(pw)
+
+	captures, durations, err := capture(pw, "test", configuration.BitmapsTestPath, configuration, internalScenarios)
+	if err != nil {
+		return fail(err)
+	}
+
+	// filter out unsuccessful captures
+	unsuccessfulCaptures := make([]screenshotter.Result, 0)
+	successfulCaptures := make([]screenshotter.Result, 0)
+	for _, screenshotterResult := range captures {
+		if screenshotterResult.Success {
+			successfulCaptures = append(successfulCaptures, screenshotterResult)
+		} else {
+			unsuccessfulCaptures = append(unsuccessfulCaptures, screenshotterResult)
+		}
+	}
+
+	// now compare the screenshots with the reference
+	compareResults, compareDurations := compare(configuration, successfulCaptures)
+
+	t2 := time.Now()
+
+	// create results for JSON output
+	results := buildResults(configuration, reportOpts.runner.RequireSameDimensions, compareResults, unsuccessfulCaptures, durations, compareDurations)
+	if err = recapture(pw, configuration, reportOpts.runner.RequireSameDimensions, results, internalScenarios, configuration.RetryCount, *reverifyTimes); err != nil {
+		return fail(err)
+	}
+	attachTraces(results, originalUrls, hosts)
+
+	fmt.Println("Collecting results for", time.Now().Sub(t2).String())
+
+	t3 := time.Now()
+
+	// create reports and stuff
+	if err = writeReports(configuration, results, reportOpts); err != nil {
+		return fail(err)
+	}
+
+	fmt.Println("Generating results for", time.Now().Sub(t3).String())
+
+	if countFailing(results, reportOpts.runner) > 0 {
+		return 1
+	}
+	return 0
+}
+
+func runReport(args []string) int {
+	fs := newFlagSet("report", "Regenerates the HTML report from results.json of a previous test run, without capturing.")
+	var opts options
+	registerConfigFlag(fs, &opts)
+	resultsPath := fs.String("results", "", "results.json to report (default <ciReportPath>/results.json)")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+
+	configuration, err := loadConfig(opts.configPath)
+	if err != nil {
+		return fail(err)
+	}
+	if *resultsPath == "" {
+		*resultsPath = filepath.Join(configuration.CiReportPath, resultsFileName)
+	}
+
+	runner, err := loadRunnerConfig(opts.configPath)
+	if err != nil {
+		return fail(err)
+	}
+	results, err := readResults(*resultsPath)
+	if err != nil {
+		return fail(err)
+	}
+	if err = writeHtmlReport(configuration, results, runner.RequireSameDimensions); err != nil {
+		return fail(err)
+	}
+	failed := countFailing(results, runner)
+	fmt.Printf("Wrote HTML report to %s: %d results, %d failed\n", configuration.HtmlReport.Path, len(results), failed)
+	return 0
+}
+
+func runList(args []string) int {
+	fs := newFlagSet("list", "Lists the internal scenarios a test run would capture, one per browser and viewport.")
+	var opts options
+	registerConfigFlag(fs, &opts)
+	registerScenariosFlag(fs, &opts)
+	asJson := fs.Bool("json", false, "print the internal scenarios as JSON")
+	filter := registerFilterFlags(fs)
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+
+	configuration, err := loadConfig(opts.configPath)
+	if err != nil {
+		return fail(err)
+	}
+	scenarios, err := loadScenarios(opts.scenariosPath)
+	if err != nil {
+		return fail(err)
+	}
+	internalScenarios := converters.ScenariosToInternal(configuration.DefaultBrowsers, configuration.Viewports, configuration.RetryCount, scenarios)
+	if err = filter.prepare(configuration); err != nil {
+		return fail(err)
+	}
+	internalScenarios = filter.apply(internalScenarios)
+
+	if *asJson {
+		internalScenariosJson, err := json.MarshalIndent(internalScenarios, "", "  ")
+		if err != nil {
+			return fail(err)
+		}
+		fmt.Println(string(internalScenariosJson))
+		return 0
+	}
+
+	for _, s := range internalScenarios {
+		fmt.Printf("%s\t%s\t%s\t%s\n", s.Id, s.Browser, s.Viewport.Label, s.Label)
+	}
+	fmt.Fprintln(os.Stderr, len(internalScenarios), "internal scenarios from", len(scenarios), "scenarios")
+	return 0
+}
+
+func runClean(args []string) int {
+	fs := newFlagSet("clean", "Removes the outputs of test runs: bitmapsTestPath, ciReportPath and the HTML report.\nReferences are never touched.")
+	var opts options
+	registerConfigFlag(fs, &opts)
+	dryRun := fs.Bool("dry-run", false, "only print what would be removed")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+
+	configuration, err := loadConfig(opts.configPath)
+	if err != nil {
+		return fail(err)
+	}
+
+	reference, err := filepath.Abs(configuration.BitmapsReferencePath)
+	if err != nil {
+		return fail(err)
+	}
+	for _, dir := range []string{configuration.BitmapsTestPath, configuration.CiReportPath, configuration.HtmlReport.Path} {
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return fail(err)
+		}
+		if rel, err := filepath.Rel(abs, reference); err == nil && !startsWithParent(rel) {
+			return fail(fmt.Errorf("refusing to remove %s, it contains the references", dir))
+		}
+		if _, err = os.Stat(dir); errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if *dryRun {
+			fmt.Println("Would remove", dir)
+			continue
+		}
+		if err = os.RemoveAll(dir); err != nil {
+			return fail(err)
+		}
+		fmt.Println("Removed", dir)
+	}
+	return 0
+}
+
+// startsWithParent reports whether the relative path leaves its base directory.
+func startsWithParent(rel string) bool {
+	return rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
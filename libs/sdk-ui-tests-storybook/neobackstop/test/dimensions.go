@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	_ "image/png"
+	"os"
+	"path/filepath"
+)
+
+type dimensions struct {
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+func (d dimensions) String() string {
+	return fmt.Sprintf("%dx%d", d.Width, d.Height)
+}
+
+// imageDimensions reads the size from the header of a PNG, without decoding the pixels.
+func imageDimensions(path string) (*dimensions, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	imageConfig, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &dimensions{Width: imageConfig.Width, Height: imageConfig.Height}, nil
+}
+
+// measureDimensions sets the sizes of the screenshot and the reference of a compared result. A size
+// that cannot be read is left out, the comparer has already reported the broken image.
+func measureDimensions(testDir string, referenceDir string, r *scenarioResult) {
+	if r.ScreenshotFileName == nil || r.ReferenceFileName == nil {
+		return
+	}
+	var err error
+	if r.TestDimensions, err = imageDimensions(filepath.Join(testDir, *r.ScreenshotFileName)); err != nil {
+		fmt.Println("WARNING: could not read the screenshot size:", err)
+	}
+	if r.ReferenceDimensions, err = imageDimensions(filepath.Join(referenceDir, *r.ReferenceFileName)); err != nil {
+		fmt.Println("WARNING: could not read the reference size:", err)
+	}
+}
+
+// sameDimensions reports whether the screenshot has the size of the reference, true when either size is
+// unknown.
+func (r scenarioResult) sameDimensions() bool {
+	return r.TestDimensions == nil || r.ReferenceDimensions == nil || *r.TestDimensions == *r.ReferenceDimensions
+}
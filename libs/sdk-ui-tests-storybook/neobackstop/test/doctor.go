@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gooddata/gooddata-neobackstop/config"
+	"github.com/gooddata/gooddata-neobackstop/converters"
+	"github.com/gooddata/gooddata-neobackstop/scenario"
+)
+
+type doctorCheck struct {
+	name   string
+	detail string
+	err    error
+}
+
+// writableDir checks that dir exists and is writable, or can be created in a writable parent.
+func writableDir(dir string) error {
+	for {
+		info, err := os.Stat(dir)
+		if errors.Is(err, os.ErrNotExist) {
+			parent := filepath.Dir(dir)
+			if parent == dir {
+				return err
+			}
+			dir = parent
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("%s is not a directory", dir)
+		}
+		f, err := os.CreateTemp(dir, ".neobackstop-doctor-*")
+		if err != nil {
+			return err
+		}
+		f.Close()
+		return os.Remove(f.Name())
+	}
+}
+
+// storybookReachable loads iframe.html from the origin of the first scenario, resolving mapped hosts
+// the same way the browser will.
+func storybookReachable(scenarios []scenario.Scenario, hosts hostMappings) (string, error) {
+	if len(scenarios) == 0 {
+		return "", errors.New("no scenarios")
+	}
+	u, err := url.Parse(scenarios[0].Url)
+	if err != nil {
+		return "", err
+	}
+	iframeUrl := url.URL{Scheme: u.Scheme, Host: u.Host, Path: "/iframe.html"}
+
+	resp, err := hosts.httpClient(10 * time.Second).Get(iframeUrl.String())
+	if err != nil {
+		return iframeUrl.String(), err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return iframeUrl.String(), fmt.Errorf("GET %s: %s", iframeUrl.String(), resp.Status)
+	}
+	return iframeUrl.String(), nil
+}
+
+func duplicateScenarioIds(scenarios []scenario.Scenario) []string {
+	seen := map[string]bool{}
+	var duplicates []string
+	for _, s := range scenarios {
+		if seen[s.Id] {
+			duplicates = append(duplicates, s.Id)
+		}
+		seen[s.Id] = true
+	}
+	return duplicates
+}
+
+func runDoctor(args []string) int {
+	fs := newFlagSet("doctor", "Checks the configuration, scenarios, output directories and that storybook is reachable.")
+	var opts options
+	var hosts hostMappings
+	registerConfigFlag(fs, &opts)
+	registerScenariosFlag(fs, &opts)
+	registerHostMapFlag(fs, &hosts)
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if err := hosts.addEnv(); err != nil {
+		return fail(err)
+	}
+
+	var checks []doctorCheck
+	configuration, err := loadConfig(opts.configPath)
+	checks = append(checks, doctorCheck{name: "config", detail: opts.configPath, err: err})
+	if err != nil {
+		return printDoctorChecks(checks)
+	}
+
+	scenarios, err := loadScenarios(opts.scenariosPath)
+	if duplicates := duplicateScenarioIds(scenarios); err == nil && len(duplicates) > 0 {
+		// ids name the screenshots, duplicates overwrite each other
+		err = fmt.Errorf("duplicate scenario ids: %s", strings.Join(duplicates, ", "))
+	}
+	checks = append(checks, doctorCheck{name: "scenarios", detail: fmt.Sprintf("%d scenarios in %s", len(scenarios), opts.scenariosPath), err: err})
+	if len(scenarios) > 0 {
+		internalScenarios := converters.ScenariosToInternal(configuration.DefaultBrowsers, configuration.Viewports, configuration.RetryCount, scenarios)
+		checks = append(checks, doctorCheck{name: "internal scenarios", detail: fmt.Sprintf("%d", len(internalScenarios))})
+
+		iframeUrl, err := storybookReachable(scenarios, hosts)
+		checks = append(checks, doctorCheck{name: "storybook", detail: iframeUrl, err: err})
+	}
+
+	checks = append(checks, referenceCheck(configuration))
+	for _, dir := range []string{configuration.BitmapsTestPath, configuration.CiReportPath, configuration.HtmlReport.Path} {
+		checks = append(checks, doctorCheck{name: "writable", detail: dir, err: writableDir(dir)})
+	}
+
+	_, err = os.Stat("./html_report_assets")
+	checks = append(checks, doctorCheck{name: "html report assets", detail: "./html_report_assets", err: err})
+
+	return printDoctorChecks(checks)
+}
+
+func referenceCheck(configuration config.Config) doctorCheck {
+	references, err := filepath.Glob(filepath.Join(configuration.BitmapsReferencePath, "*.png"))
+	if err == nil && len(references) == 0 {
+		err = fmt.Errorf("no references in %s, run approve first", configuration.BitmapsReferencePath)
+	}
+	return doctorCheck{name: "references", detail: fmt.Sprintf("%d in %s", len(references), configuration.BitmapsReferencePath), err: err}
+}
+
+func printDoctorChecks(checks []doctorCheck) int {
+	code := 0
+	for _, c := range checks {
+		if c.err != nil {
+			fmt.Printf("FAIL %s: %s\n     %v\n", c.name, c.detail, c.err)
+			code = 1
+			continue
+		}
+		fmt.Printf("OK   %s: %s\n", c.name, c.detail)
+	}
+	return code
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/gooddata/gooddata-neobackstop/config"
+	"github.com/gooddata/gooddata-neobackstop/internals"
+)
+
+// stringList implements flag.Value for repeated string flags.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// regexpList implements flag.Value for repeated regular expression flags.
+type regexpList []*regexp.Regexp
+
+func (l *regexpList) String() string {
+	parts := make([]string, 0, len(*l))
+	for _, re := range *l {
+		parts = append(parts, re.String())
+	}
+	return strings.Join(parts, ",")
+}
+
+func (l *regexpList) Set(value string) error {
+	re, err := regexp.Compile(value)
+	if err != nil {
+		return err
+	}
+	*l = append(*l, re)
+	return nil
+}
+
+// globList implements flag.Value for repeated glob flags.
+type globList []string
+
+func (l *globList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *globList) Set(value string) error {
+	if _, err := path.Match(value, ""); err != nil {
+		return fmt.Errorf("invalid glob %q: %w", value, err)
+	}
+	*l = append(*l, value)
+	return nil
+}
+
+// scenarioFilter selects internal scenarios. Values of one flag are alternatives, different flags must
+// all match.
+type scenarioFilter struct {
+	labels     regexpList
+	labelGlobs globList
+	kinds      stringList
+	browsers   stringList
+	viewports  stringList
+	idsFile    string
+
+	ids map[string]bool
+}
+
+func registerFilterFlags(fs *flag.FlagSet) *scenarioFilter {
+	f := &scenarioFilter{}
+	fs.Var(&f.labels, "label", "select scenarios whose label matches the regular expression, repeatable")
+	fs.Var(&f.labelGlobs, "label-glob", "select scenarios whose label matches the glob, e.g. '*PivotTable*', repeatable")
+	fs.Var(&f.kinds, "kind", "select scenarios whose story kind starts with the prefix, e.g. '04 Stories For Pluggable Vis/BarChart', repeatable")
+	fs.Var(&f.browsers, "browser", "select scenarios captured in the browser alias, repeatable")
+	fs.Var(&f.viewports, "viewport", "select scenarios captured in the viewport label, repeatable")
+	fs.StringVar(&f.idsFile, "ids-file", "", "select scenarios whose id is listed in the file, one per line, # starts a comment")
+	return f
+}
+
+func (f *scenarioFilter) active() bool {
+	return len(f.labels) > 0 || len(f.labelGlobs) > 0 || len(f.kinds) > 0 || len(f.browsers) > 0 || len(f.viewports) > 0 || f.idsFile != ""
+}
+
+// prepare checks the filter against the configuration and reads the ids file.
+func (f *scenarioFilter) prepare(configuration config.Config) error {
+	for _, alias := range f.browsers {
+		if _, ok := configuration.Browsers[alias]; !ok {
+			return fmt.Errorf("--browser %q is not in the configured browsers", alias)
+		}
+	}
+	for _, label := range f.viewports {
+		if !slices.ContainsFunc(configuration.Viewports, func(v config.Viewport) bool { return v.Label == label }) {
+			return fmt.Errorf("--viewport %q is not in the configured viewports", label)
+		}
+	}
+	if f.idsFile == "" {
+		return nil
+	}
+
+	idsFile, err := os.Open(f.idsFile)
+	if err != nil {
+		return err
+	}
+	defer idsFile.Close()
+
+	f.ids = map[string]bool{}
+	scanner := bufio.NewScanner(idsFile)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		if id := strings.TrimSpace(line); id != "" {
+			f.ids[id] = true
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return err
+	}
+	if len(f.ids) == 0 {
+		return errors.New("--ids-file lists no scenario ids")
+	}
+	return nil
+}
+
+// storyKind is the part of the scenario label before the story name, see scenarioLabel in
+// scripts/scenarios.config.ts.
+func storyKind(label string) string {
+	kind, _, _ := strings.Cut(label, " - ")
+	return kind
+}
+
+// matchLabelGlob matches the whole label. Labels are story kinds like "04 Stories For Pluggable
+// Vis/BarChart - basic", so unlike in path.Match a * also matches a slash.
+func matchLabelGlob(glob string, label string) bool {
+	matched, _ := path.Match(strings.ReplaceAll(glob, "/", "\x00"), strings.ReplaceAll(label, "/", "\x00"))
+	return matched
+}
+
+func (f *scenarioFilter) matches(s internals.Scenario) bool {
+	if len(f.labels) > 0 && !slices.ContainsFunc(f.labels, func(re *regexp.Regexp) bool { return re.MatchString(s.Label) }) {
+		return false
+	}
+	if len(f.labelGlobs) > 0 && !slices.ContainsFunc(f.labelGlobs, func(glob string) bool { return matchLabelGlob(glob, s.Label) }) {
+		return false
+	}
+	if len(f.kinds) > 0 && !slices.ContainsFunc(f.kinds, func(prefix string) bool { return strings.HasPrefix(storyKind(s.Label), prefix) }) {
+		return false
+	}
+	if len(f.browsers) > 0 && !slices.Contains(f.browsers, string(s.Browser)) {
+		return false
+	}
+	if len(f.viewports) > 0 && !slices.Contains(f.viewports, s.Viewport.Label) {
+		return false
+	}
+	if f.ids != nil && !f.ids[s.Id] {
+		return false
+	}
+	return true
+}
+
+// selectScenarios keeps the internal scenarios selected by the filter.
+func selectScenarios(configuration config.Config, internalScenarios []internals.Scenario, filter *scenarioFilter) ([]internals.Scenario, error) {
+	if !filter.active() {
+		return internalScenarios, nil
+	}
+	if err := filter.prepare(configuration); err != nil {
+		return nil, err
+	}
+	selected := filter.apply(internalScenarios)
+	if len(selected) == 0 {
+		return nil, errors.New("no internal scenarios match the filter")
+	}
+	fmt.Println("Selected", len(selected), "internal scenarios")
+	return selected, nil
+}
+
+func (f *scenarioFilter) apply(internalScenarios []internals.Scenario) []internals.Scenario {
+	if !f.active() {
+		return internalScenarios
+	}
+	selected := make([]internals.Scenario, 0, len(internalScenarios))
+	for _, s := range internalScenarios {
+		if f.matches(s) {
+			selected = append(selected, s)
+		}
+	}
+	return selected
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/gooddata/gooddata-neobackstop/config"
+	"github.com/gooddata/gooddata-neobackstop/internals"
+)
+
+func filterScenarios() []internals.Scenario {
+	desktop := config.Viewport{Label: "desktop", Width: 1280, Height: 800}
+	mobile := config.Viewport{Label: "mobile", Width: 375, Height: 667}
+	return []internals.Scenario{
+		{Id: "bar-chart--basic", Label: "04 Stories For Pluggable Vis/BarChart - basic", Browser: "chromium", Viewport: desktop},
+		{Id: "bar-chart--basic", Label: "04 Stories For Pluggable Vis/BarChart - basic", Browser: "firefox", Viewport: mobile},
+		{Id: "pivot-table--sorted", Label: "04 Stories For Pluggable Vis/PivotTable - sorted", Browser: "chromium", Viewport: desktop},
+		{Id: "pivot-table--menu", Label: "01 Stories For Pivot Table/Menu - aggregations", Browser: "chromium", Viewport: mobile},
+	}
+}
+
+func selectedIds(scenarios []internals.Scenario) []string {
+	ids := make([]string, 0, len(scenarios))
+	for _, s := range scenarios {
+		ids = append(ids, s.Id+"_"+string(s.Browser)+"_"+s.Viewport.Label)
+	}
+	return ids
+}
+
+func TestScenarioFilterApply(t *testing.T) {
+	tests := []struct {
+		name  string
+		flags func(f *scenarioFilter)
+		want  []string
+	}{
+		{
+			name:  "inactive",
+			flags: func(f *scenarioFilter) {},
+			want:  []string{"bar-chart--basic_chromium_desktop", "bar-chart--basic_firefox_mobile", "pivot-table--sorted_chromium_desktop", "pivot-table--menu_chromium_mobile"},
+		},
+		{
+			name:  "labels are alternatives",
+			flags: func(f *scenarioFilter) { _ = f.labels.Set("BarChart"); _ = f.labels.Set("aggregations$") },
+			want:  []string{"bar-chart--basic_chromium_desktop", "bar-chart--basic_firefox_mobile", "pivot-table--menu_chromium_mobile"},
+		},
+		{
+			name:  "glob matches the whole label",
+			flags: func(f *scenarioFilter) { _ = f.labelGlobs.Set("*PivotTable*") },
+			want:  []string{"pivot-table--sorted_chromium_desktop"},
+		},
+		{
+			name:  "glob with a slash",
+			flags: func(f *scenarioFilter) { _ = f.labelGlobs.Set("01 Stories*/Menu - *") },
+			want:  []string{"pivot-table--menu_chromium_mobile"},
+		},
+		{
+			name:  "kind is a prefix of the story kind",
+			flags: func(f *scenarioFilter) { f.kinds = stringList{"04 Stories For Pluggable Vis/Pivot"} },
+			want:  []string{"pivot-table--sorted_chromium_desktop"},
+		},
+		{
+			name:  "kind does not match the story name",
+			flags: func(f *scenarioFilter) { f.kinds = stringList{"04 Stories For Pluggable Vis/BarChart - basic"} },
+			want:  []string{},
+		},
+		{
+			name: "different flags must all match",
+			flags: func(f *scenarioFilter) {
+				f.kinds = stringList{"04 Stories For Pluggable Vis"}
+				f.browsers = stringList{"chromium"}
+				f.viewports = stringList{"desktop"}
+			},
+			want: []string{"bar-chart--basic_chromium_desktop", "pivot-table--sorted_chromium_desktop"},
+		},
+		{
+			name: "ids",
+			flags: func(f *scenarioFilter) {
+				f.idsFile = "ids.txt"
+				f.ids = map[string]bool{"bar-chart--basic": true}
+			},
+			want: []string{"bar-chart--basic_chromium_desktop", "bar-chart--basic_firefox_mobile"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &scenarioFilter{}
+			tt.flags(f)
+			if got := selectedIds(f.apply(filterScenarios())); !slices.Equal(got, tt.want) {
+				t.Errorf("selected %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGlobListRejectsInvalidGlob(t *testing.T) {
+	var l globList
+	if err := l.Set("[PivotTable"); err == nil {
+		t.Fatal("invalid glob accepted")
+	}
+}
+
+func TestScenarioFilterPrepare(t *testing.T) {
+	configuration := config.Config{
+		Browsers:  map[string]config.Browser{"chromium": {}},
+		Viewports: []config.Viewport{{Label: "desktop"}},
+	}
+
+	idsFile := filepath.Join(t.TempDir(), "ids.txt")
+	if err := os.WriteFile(idsFile, []byte("# failed on main\nbar-chart--basic\n  pivot-table--menu # flaky\n\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f := &scenarioFilter{idsFile: idsFile}
+	if err := f.prepare(configuration); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.ids) != 2 || !f.ids["bar-chart--basic"] || !f.ids["pivot-table--menu"] {
+		t.Errorf("ids = %v", f.ids)
+	}
+
+	if err := (&scenarioFilter{browsers: stringList{"webkit"}}).prepare(configuration); err == nil {
+		t.Error("unknown browser accepted")
+	}
+	if err := (&scenarioFilter{viewports: stringList{"tablet"}}).prepare(configuration); err == nil {
+		t.Error("unknown viewport accepted")
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gooddata/gooddata-neobackstop/browser"
+	"github.com/gooddata/gooddata-neobackstop/config"
+)
+
+// hostMapping points a hostname used in scenario urls (e.g. ag-grid.com) at the server that actually
+// serves it (e.g. 127.0.0.1 or the serve container).
+type hostMapping struct {
+	Host   string
+	Target string
+}
+
+// hostMappings implements flag.Value for repeated --host-map=HOST=TARGET flags.
+type hostMappings []hostMapping
+
+func (m *hostMappings) String() string {
+	parts := make([]string, 0, len(*m))
+	for _, mapping := range *m {
+		parts = append(parts, mapping.Host+"="+mapping.Target)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (m *hostMappings) Set(value string) error {
+	for _, part := range strings.Split(value, ",") {
+		host, target, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok || host == "" || target == "" {
+			return fmt.Errorf("expected HOST=TARGET, got %q", part)
+		}
+		*m = append(*m, hostMapping{Host: host, Target: target})
+	}
+	return nil
+}
+
+// addEnv adds the mappings in NEOBACKSTOP_HOST_MAP to those of the --host-map flags.
+func (m *hostMappings) addEnv() error {
+	hostMapEnv := os.Getenv("NEOBACKSTOP_HOST_MAP")
+	if hostMapEnv == "" {
+		return nil
+	}
+	if err := m.Set(hostMapEnv); err != nil {
+		return fmt.Errorf("NEOBACKSTOP_HOST_MAP: %w", err)
+	}
+	return nil
+}
+
+// httpClient returns a client that resolves the mapped hosts the way chromium does, a connection to a
+// mapped host goes to its target on the port of the url. Requests to the runner's own helpers, like the
+// traces and the doctor checks, must never reach the real hosts.
+func (m hostMappings) httpClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, network string, addr string) (net.Conn, error) {
+			host, port, err := net.SplitHostPort(addr)
+			if err != nil {
+				return nil, err
+			}
+			for _, mapping := range m {
+				if strings.EqualFold(mapping.Host, host) {
+					addr = net.JoinHostPort(mapping.Target, port)
+					break
+				}
+			}
+			return dialer.DialContext(ctx, network, addr)
+		},
+	}
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
+
+// hostResolverRules formats the mappings for Chromium's --host-resolver-rules. The port of the scenario
+// url is kept, only the name resolution changes.
+func (m hostMappings) hostResolverRules() string {
+	rules := make([]string, 0, len(m))
+	for _, mapping := range m {
+		rules = append(rules, "MAP "+mapping.Host+" "+mapping.Target)
+	}
+	return strings.Join(rules, ", ")
+}
+
+// applyHostMappings adds the host resolver rules to the args of every chromium browser. Firefox has no
+// equivalent launch argument, scenarios captured in it still need the hostnames to resolve.
+func applyHostMappings(configuration *config.Config, mappings hostMappings) {
+	if len(mappings) == 0 {
+		return
+	}
+	for alias, b := range configuration.Browsers {
+		if b.Name != browser.Chromium {
+			fmt.Fprintf(os.Stderr, "WARNING: host mapping is not supported for %s (browser %s)\n", b.Name, alias)
+			continue
+		}
+		b.Args = append(slices.Clone(b.Args), "--host-resolver-rules="+mappings.hostResolverRules())
+		configuration.Browsers[alias] = b
+	}
+	fmt.Println("Mapping hosts:", mappings.String())
+}
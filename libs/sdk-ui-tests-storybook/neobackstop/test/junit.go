@@ -0,0 +1,190 @@
+package main
+
+import (
+	"cmp"
+	"encoding/xml"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/gooddata/gooddata-neobackstop/config"
+)
+
+const junitFileName = "junit.xml"
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Errors   int              `xml:"errors,attr"`
+	Skipped  int              `xml:"skipped,attr"`
+	Time     string           `xml:"time,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Errors   int             `xml:"errors,attr"`
+	Skipped  int             `xml:"skipped,attr"`
+	Time     string          `xml:"time,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitProblem `xml:"failure,omitempty"`
+	Error     *junitProblem `xml:"error,omitempty"`
+	Skipped   *junitSkipped `xml:"skipped,omitempty"`
+	SystemOut *junitOutput  `xml:"system-out,omitempty"`
+}
+
+type junitProblem struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",cdata"`
+}
+
+type junitSkipped struct {
+	Message string `xml:"message,attr"`
+}
+
+type junitOutput struct {
+	Text string `xml:",cdata"`
+}
+
+func junitSeconds(ms int64) string {
+	return fmt.Sprintf("%.3f", float64(ms)/1000)
+}
+
+// writeJunit writes junit.xml next to results.json, one testcase per internal scenario in one
+// testsuite per story kind. Mismatches are failures, scenarios that could not be compared are errors,
+// new scenarios are failures with newFails and skipped otherwise, flaky scenarios are failures with
+// failOnFlaky.
+func writeJunit(configuration config.Config, results []scenarioResult, runner runnerConfig) error {
+	// image paths are relative to the report, like in the HTML report
+	imagePath := func(dir string, fileName *string) string {
+		if fileName == nil {
+			return ""
+		}
+		rel, err := filepath.Rel(configuration.CiReportPath, filepath.Join(dir, *fileName))
+		if err != nil {
+			return filepath.Join(dir, *fileName)
+		}
+		return filepath.ToSlash(rel)
+	}
+
+	suites := map[string]*junitTestSuite{}
+	suiteMs := map[string]int64{}
+	var totalMs int64
+	for _, r := range results {
+		kind := storyKind(r.Scenario.Label)
+		suite, ok := suites[kind]
+		if !ok {
+			suite = &junitTestSuite{Name: kind}
+			suites[kind] = suite
+		}
+
+		testCase := junitTestCase{
+			Name:      fmt.Sprintf("%s [%s, %s]", r.Scenario.Label, r.Scenario.Browser, r.Scenario.Viewport.Label),
+			ClassName: kind,
+			Time:      junitSeconds(r.DurationMs + r.CompareMs),
+		}
+
+		var images []string
+		if path := imagePath(configuration.BitmapsTestPath, r.ScreenshotFileName); path != "" {
+			images = append(images, "test: "+path)
+		}
+		if path := imagePath(configuration.BitmapsReferencePath, r.ReferenceFileName); path != "" {
+			images = append(images, "reference: "+path)
+		}
+		if path := imagePath(configuration.BitmapsTestPath, r.DiffFileName); path != "" {
+			images = append(images, "diff: "+path)
+		}
+		details := strings.Join(append([]string{"id: " + r.Scenario.Id, "url: " + r.Scenario.Url}, images...), "\n")
+
+		switch r.Status {
+		case statusError:
+			message := "scenario could not be captured"
+			if r.Error != nil {
+				message = *r.Error
+			}
+			testCase.Error = &junitProblem{Message: message, Type: "error", Text: message + "\n\n" + details}
+			suite.Errors++
+		case statusFail:
+			message := "screenshot does not match the reference"
+			if r.MisMatchPercentage != nil {
+				message = fmt.Sprintf("screenshot differs from the reference by %.2f%%", *r.MisMatchPercentage)
+				if r.Scenario.MisMatchThreshold != nil {
+					message += fmt.Sprintf(", threshold %.2f%%", *r.Scenario.MisMatchThreshold)
+				}
+			}
+			if !r.sameDimensions() {
+				message += fmt.Sprintf(", size changed from %s to %s", r.ReferenceDimensions, r.TestDimensions)
+			}
+			testCase.Failure = &junitProblem{Message: message, Type: "mismatch", Text: details}
+			suite.Failures++
+		case statusNew:
+			if runner.NewFails {
+				testCase.Failure = &junitProblem{Message: "no reference", Type: "new", Text: details}
+				suite.Failures++
+			} else {
+				testCase.Skipped = &junitSkipped{Message: "no reference"}
+				testCase.SystemOut = &junitOutput{Text: details}
+				suite.Skipped++
+			}
+		case statusFlaky:
+			statuses := make([]string, 0, len(r.Attempts))
+			for _, a := range r.Attempts {
+				statuses = append(statuses, string(a.Status))
+			}
+			message := "attempts disagree: " + strings.Join(statuses, ", ")
+			if r.Status.fails(runner) {
+				testCase.Failure = &junitProblem{Message: message, Type: "flaky", Text: details}
+				suite.Failures++
+			} else {
+				testCase.SystemOut = &junitOutput{Text: message + "\n" + details}
+			}
+		default:
+			testCase.SystemOut = &junitOutput{Text: "status: " + string(r.Status) + "\n" + details}
+		}
+
+		suite.Tests++
+		suite.Cases = append(suite.Cases, testCase)
+		suiteMs[kind] += r.DurationMs + r.CompareMs
+		totalMs += r.DurationMs + r.CompareMs
+	}
+
+	report := junitTestSuites{Name: configuration.Id, Time: junitSeconds(totalMs)}
+	for _, kind := range slices.Sorted(maps.Keys(suites)) {
+		suite := suites[kind]
+		suite.Time = junitSeconds(suiteMs[kind])
+		slices.SortFunc(suite.Cases, func(a, b junitTestCase) int {
+			return cmp.Compare(a.Name, b.Name)
+		})
+
+		report.Tests += suite.Tests
+		report.Failures += suite.Failures
+		report.Errors += suite.Errors
+		report.Skipped += suite.Skipped
+		report.Suites = append(report.Suites, *suite)
+	}
+
+	junitXml, err := xml.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	junitXml = append([]byte(xml.Header), junitXml...)
+
+	if err = os.WriteFile(filepath.Join(configuration.CiReportPath, junitFileName), junitXml, 0755); err != nil {
+		return fmt.Errorf("error writing file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gooddata/gooddata-neobackstop/config"
+	"github.com/gooddata/gooddata-neobackstop/internals"
+	"github.com/gooddata/gooddata-neobackstop/result"
+)
+
+func reportResult(label string, s status) scenarioResult {
+	fileName := label + ".png"
+	r := scenarioResult{
+		Result: result.Result{
+			Scenario:           internals.Scenario{Id: label, Label: label, Browser: "chromium", Viewport: config.Viewport{Label: "desktop"}},
+			ScreenshotFileName: &fileName,
+		},
+		Status:     s,
+		DurationMs: 1500,
+		CompareMs:  500,
+	}
+	switch s {
+	case statusPass, statusFail:
+		r.ReferenceFileName = &fileName
+		matches := s == statusPass
+		r.MatchesReference = &matches
+	case statusError:
+		r.ScreenshotFileName = nil
+	}
+	if s == statusFail {
+		diffFileName := "diff_" + fileName
+		mismatch := 3.5
+		r.DiffFileName = &diffFileName
+		r.MisMatchPercentage = &mismatch
+	}
+	return r
+}
+
+func readJunit(t *testing.T, dir string) junitTestSuites {
+	t.Helper()
+	content, err := os.ReadFile(filepath.Join(dir, junitFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report junitTestSuites
+	if err = xml.Unmarshal(content, &report); err != nil {
+		t.Fatal(err)
+	}
+	return report
+}
+
+func TestWriteJunitGroupsByStoryKind(t *testing.T) {
+	dir := t.TempDir()
+	configuration := config.Config{
+		Id:                   "storybook",
+		CiReportPath:         filepath.Join(dir, "ci_report"),
+		BitmapsTestPath:      filepath.Join(dir, "test"),
+		BitmapsReferencePath: filepath.Join(dir, "reference"),
+	}
+	if err := os.Mkdir(configuration.CiReportPath, 0755); err != nil {
+		t.Fatal(err)
+	}
+	results := []scenarioResult{
+		reportResult("Kind B - second", statusPass),
+		reportResult("Kind A - failing", statusFail),
+		reportResult("Kind A - broken", statusError),
+		reportResult("Kind B - added", statusNew),
+		reportResult("Kind A - other", statusPass),
+	}
+
+	if err := writeJunit(configuration, results, runnerConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	report := readJunit(t, configuration.CiReportPath)
+	if report.Tests != 5 || report.Failures != 1 || report.Errors != 1 || report.Skipped != 1 || report.Time != "10.000" {
+		t.Errorf("totals = %d tests, %d failures, %d errors, %d skipped in %s", report.Tests, report.Failures, report.Errors, report.Skipped, report.Time)
+	}
+	if len(report.Suites) != 2 || report.Suites[0].Name != "Kind A" || report.Suites[1].Name != "Kind B" {
+		t.Fatalf("suites = %+v, want Kind A and Kind B", report.Suites)
+	}
+
+	kindA := report.Suites[0]
+	if kindA.Tests != 3 || kindA.Failures != 1 || kindA.Errors != 1 || kindA.Skipped != 0 {
+		t.Errorf("Kind A = %+v", kindA)
+	}
+	names := []string{kindA.Cases[0].Name, kindA.Cases[1].Name, kindA.Cases[2].Name}
+	if names[0] != "Kind A - broken [chromium, desktop]" || names[1] != "Kind A - failing [chromium, desktop]" || names[2] != "Kind A - other [chromium, desktop]" {
+		t.Errorf("cases = %v, want them sorted by name", names)
+	}
+	failing := kindA.Cases[1]
+	if failing.Failure == nil || failing.Failure.Message != "screenshot differs from the reference by 3.50%" {
+		t.Errorf("failure = %+v", failing.Failure)
+	}
+	if want := "id: Kind A - failing\nurl: \ntest: ../test/Kind A - failing.png\nreference: ../reference/Kind A - failing.png\ndiff: ../test/diff_Kind A - failing.png"; failing.Failure != nil && failing.Failure.Text != want {
+		t.Errorf("failure text = %q, want %q", failing.Failure.Text, want)
+	}
+	if broken := kindA.Cases[0]; broken.Error == nil || broken.Error.Message != "scenario could not be captured" {
+		t.Errorf("error = %+v, want the fallback message", broken.Error)
+	}
+
+	added := report.Suites[1].Cases[0]
+	if added.Skipped == nil || added.Failure != nil {
+		t.Errorf("new scenario = %+v, want it skipped", added)
+	}
+}
+
+func TestWriteJunitNewFails(t *testing.T) {
+	dir := t.TempDir()
+	configuration := config.Config{CiReportPath: dir}
+	if err := writeJunit(configuration, []scenarioResult{reportResult("Kind - added", statusNew)}, runnerConfig{NewFails: true}); err != nil {
+		t.Fatal(err)
+	}
+	report := readJunit(t, dir)
+	if report.Failures != 1 || report.Skipped != 0 || report.Suites[0].Cases[0].Failure == nil {
+		t.Errorf("report = %+v, want the new scenario to fail", report)
+	}
+}
+
+func TestWriteJunitFlaky(t *testing.T) {
+	flaky := reportResult("Kind - flaky", statusFlaky)
+	flaky.Attempts = attempts(statusFail, statusPass)
+
+	dir := t.TempDir()
+	if err := writeJunit(config.Config{CiReportPath: dir}, []scenarioResult{flaky}, runnerConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	report := readJunit(t, dir)
+	if testCase := report.Suites[0].Cases[0]; report.Failures != 0 || testCase.SystemOut == nil {
+		t.Errorf("report = %+v, want the flaky scenario to pass", report)
+	}
+
+	if err := writeJunit(config.Config{CiReportPath: dir}, []scenarioResult{flaky}, runnerConfig{FailOnFlaky: true}); err != nil {
+		t.Fatal(err)
+	}
+	if report = readJunit(t, dir); report.Failures != 1 || report.Suites[0].Cases[0].Failure == nil || report.Suites[0].Cases[0].Failure.Message != "attempts disagree: fail, pass" {
+		t.Errorf("report = %+v, want the flaky scenario to fail with failOnFlaky", report)
+	}
+}
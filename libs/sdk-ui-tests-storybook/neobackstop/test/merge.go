@@ -0,0 +1,158 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/gooddata/gooddata-neobackstop/config"
+)
+
+func runMerge(args []string) int {
+	fs := newFlagSet("merge", "Combines the results of test --shard runs into one results.json and HTML report. The\narguments SHARD_DIR... are the output directories of the shards, each containing the directories\nnamed like the last elements of bitmapsTestPath and ciReportPath. The screenshots, diffs and\nretry and re-verification attempts are copied into bitmapsTestPath. Exits with 1 when a scenario of any shard fails.")
+	var opts options
+	registerConfigFlag(fs, &opts)
+	reportOpts := registerReportFlags(fs)
+	if code, ok := parseFlagsAndArgs(fs, args); !ok {
+		return code
+	}
+	if fs.NArg() == 0 {
+		fmt.Fprintln(fs.Output(), "no shard directories")
+		fs.Usage()
+		return 2
+	}
+
+	if err := reportOpts.validate(); err != nil {
+		return fail(err)
+	}
+
+	configuration, err := loadConfig(opts.configPath)
+	if err != nil {
+		return fail(err)
+	}
+	if err = reportOpts.load(opts.configPath); err != nil {
+		return fail(err)
+	}
+	if err = os.MkdirAll(configuration.BitmapsTestPath, 0777); err != nil {
+		return fail(err)
+	}
+
+	var results []scenarioResult
+	shardOf := map[string]string{}
+	for _, dir := range fs.Args() {
+		shardResults, err := mergeShard(configuration, dir)
+		if err != nil {
+			return fail(err)
+		}
+		for _, r := range shardResults {
+			key := scenarioKey(r.Scenario)
+			if other, ok := shardOf[key]; ok {
+				return fail(fmt.Errorf("scenario %s is in the results of %s and %s, were the shards run with the same --shard count and filters?", key, other, dir))
+			}
+			shardOf[key] = dir
+		}
+		fmt.Println("Merged", len(shardResults), "results from", dir)
+		results = append(results, shardResults...)
+	}
+
+	if err = writeReports(configuration, results, reportOpts); err != nil {
+		return fail(err)
+	}
+
+	failed := countFailing(results, reportOpts.runner)
+	fmt.Printf("Merged %d shards: %d results, %d failed\n", fs.NArg(), len(results), failed)
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// mergeShard reads the results of the shard in dir and copies its screenshots and diffs into
+// bitmapsTestPath, and the directories of the retries and re-verifications the attempts point to.
+func mergeShard(configuration config.Config, dir string) ([]scenarioResult, error) {
+	resultsPath := filepath.Join(dir, filepath.Base(configuration.CiReportPath), resultsFileName)
+	results, err := readResults(resultsPath)
+	if err != nil {
+		return nil, err
+	}
+
+	bitmapsDir := filepath.Join(dir, filepath.Base(configuration.BitmapsTestPath))
+	same, err := sameDir(bitmapsDir, configuration.BitmapsTestPath)
+	if err != nil || same {
+		// a shard that ran in place already has its bitmaps there
+		return results, err
+	}
+
+	for _, r := range results {
+		for _, fileName := range []*string{r.ScreenshotFileName, r.DiffFileName} {
+			if fileName == nil {
+				continue
+			}
+			err = copyFile(filepath.Join(bitmapsDir, *fileName), filepath.Join(configuration.BitmapsTestPath, *fileName))
+			if errors.Is(err, os.ErrNotExist) {
+				// the HTML report shows the missing image, the result still counts
+				fmt.Fprintf(os.Stderr, "WARNING: %s of %s is missing in %s\n", *fileName, r.Scenario.Id, bitmapsDir)
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	attemptDirs := map[string]bool{}
+	for _, r := range results {
+		for _, a := range r.Attempts {
+			if a.Dir != "" {
+				attemptDirs[a.Dir] = true
+			}
+		}
+	}
+	for attemptDir := range attemptDirs {
+		if err = copyDir(filepath.Join(bitmapsDir, attemptDir), filepath.Join(configuration.BitmapsTestPath, attemptDir)); err != nil {
+			return nil, err
+		}
+	}
+	return results, nil
+}
+
+// copyDir copies the files of src into dst, the shards write different files into the same attempt
+// directories.
+func copyDir(src string, dst string) error {
+	entries, err := os.ReadDir(src)
+	if errors.Is(err, os.ErrNotExist) {
+		fmt.Fprintf(os.Stderr, "WARNING: %s is missing\n", src)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(dst, 0777); err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		if err = copyFile(filepath.Join(src, entry.Name()), filepath.Join(dst, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sameDir(a string, b string) (bool, error) {
+	aInfo, err := os.Stat(a)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	bInfo, err := os.Stat(b)
+	if err != nil {
+		return false, err
+	}
+	return os.SameFile(aInfo, bInfo), nil
+}
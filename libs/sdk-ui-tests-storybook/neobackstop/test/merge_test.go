@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gooddata/gooddata-neobackstop/config"
+)
+
+func TestMergeShardCopiesAttempts(t *testing.T) {
+	root := t.TempDir()
+	configuration := config.Config{
+		BitmapsTestPath: filepath.Join(root, "output", "test"),
+		CiReportPath:    filepath.Join(root, "output", "ci-report"),
+	}
+	shardDir := filepath.Join(root, "shards", "1")
+
+	flaky := reportResult("Kind - flaky", statusFlaky)
+	flaky.Attempts = attempts(statusFail, statusFail, statusPass)
+	flaky.Attempts[1].Dir = "retry-1"
+	flaky.Attempts[2].Dir = "retry-2"
+
+	files := map[string]string{
+		"ci-report/" + resultsFileName:  "",
+		"test/Kind - flaky.png":         "first",
+		"test/retry-1/Kind - flaky.png": "second",
+		"test/retry-2/Kind - flaky.png": "third",
+	}
+	for name, content := range files {
+		path := filepath.Join(shardDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	resultsJson, err := json.Marshal([]scenarioResult{flaky})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(filepath.Join(shardDir, "ci-report", resultsFileName), resultsJson, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.MkdirAll(configuration.BitmapsTestPath, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = mergeShard(configuration, shardDir); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{"Kind - flaky.png": "first", "retry-1/Kind - flaky.png": "second", "retry-2/Kind - flaky.png": "third"} {
+		content, err := os.ReadFile(filepath.Join(configuration.BitmapsTestPath, name))
+		if err != nil {
+			t.Errorf("%s not merged: %v", name, err)
+			continue
+		}
+		if string(content) != want {
+			t.Errorf("%s = %q, want %q", name, content, want)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/gooddata/gooddata-neobackstop/config"
+	"github.com/gooddata/gooddata-neobackstop/scenario"
+)
+
+// options are the input files shared by the commands.
+type options struct {
+	configPath    string
+	scenariosPath string
+}
+
+func newFlagSet(name string, description string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: neobackstop %s [flags]\n\n%s\n\nFlags:\n", name, description)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+func registerConfigFlag(fs *flag.FlagSet, opts *options) {
+	fs.StringVar(&opts.configPath, "config", "../config.json", "config.json file path")
+}
+
+func registerScenariosFlag(fs *flag.FlagSet, opts *options) {
+	fs.StringVar(&opts.scenariosPath, "scenarios", "../scenarios.json", "scenarios.json file path")
+}
+
+// parseFlags parses the command's flags. When the command should not run it returns false with the exit
+// code: 0 after --help, 2 for invalid flags or arguments.
+func parseFlags(fs *flag.FlagSet, args []string) (int, bool) {
+	if code, ok := parseFlagsAndArgs(fs, args); !ok {
+		return code, false
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintf(fs.Output(), "unexpected arguments: %s\n", strings.Join(fs.Args(), " "))
+		fs.Usage()
+		return 2, false
+	}
+	return 0, true
+}
+
+// parseFlagsAndArgs is parseFlags for commands that take arguments after the flags.
+func parseFlagsAndArgs(fs *flag.FlagSet, args []string) (int, bool) {
+	err := fs.Parse(args)
+	if errors.Is(err, flag.ErrHelp) {
+		return 0, false
+	}
+	if err != nil {
+		// the flag set has already printed the error and the usage
+		return 2, false
+	}
+	return 0, true
+}
+
+// fail prints err and returns the exit code for a command that could not run.
+func fail(err error) int {
+	fmt.Fprintln(os.Stderr, "error:", err)
+	return 1
+}
+
+func loadConfig(configPath string) (config.Config, error) {
+	var configuration config.Config
+
+	configFileBytes, err := os.ReadFile(configPath)
+	if err != nil {
+		return configuration, fmt.Errorf("read config: %w", err)
+	}
+	if err = json.Unmarshal(configFileBytes, &configuration); err != nil {
+		return configuration, fmt.Errorf("parse %s: %w", configPath, err)
+	}
+	if err = validateConfig(configuration); err != nil {
+		return configuration, fmt.Errorf("invalid %s: %w", configPath, err)
+	}
+	return configuration, nil
+}
+
+// runnerConfig holds the keys of config.json only this runner reads, neobackstop ignores them.
+type runnerConfig struct {
+	// NewFails makes scenarios without a reference fail the run
+	NewFails bool `json:"newFails"`
+	// RequireSameDimensions makes screenshots that changed size fail, even within the threshold
+	RequireSameDimensions bool `json:"requireSameDimensions"`
+	// FailOnFlaky makes flaky scenarios fail the run, by default they pass like a pass on retry did
+	FailOnFlaky bool `json:"failOnFlaky"`
+}
+
+func loadRunnerConfig(configPath string) (runnerConfig, error) {
+	var runner runnerConfig
+
+	configFileBytes, err := os.ReadFile(configPath)
+	if err != nil {
+		return runner, fmt.Errorf("read config: %w", err)
+	}
+	if err = json.Unmarshal(configFileBytes, &runner); err != nil {
+		return runner, fmt.Errorf("parse %s: %w", configPath, err)
+	}
+	return runner, nil
+}
+
+func validateConfig(configuration config.Config) error {
+	if len(configuration.Browsers) == 0 {
+		return errors.New("no browsers configured")
+	}
+	for _, alias := range configuration.DefaultBrowsers {
+		if _, ok := configuration.Browsers[alias]; !ok {
+			return fmt.Errorf("default browser %q is not in browsers", alias)
+		}
+	}
+	if len(configuration.Viewports) == 0 {
+		return errors.New("no viewports configured")
+	}
+	if configuration.AsyncCaptureLimit < 1 || configuration.AsyncCompareLimit < 1 {
+		return errors.New("asyncCaptureLimit and asyncCompareLimit must be at least 1")
+	}
+	if configuration.BitmapsReferencePath == "" || configuration.BitmapsTestPath == "" || configuration.CiReportPath == "" || configuration.HtmlReport.Path == "" {
+		return errors.New("bitmapsReferencePath, bitmapsTestPath, ciReportPath and htmlReport.path are required")
+	}
+	return nil
+}
+
+func loadScenarios(scenariosPath string) ([]scenario.Scenario, error) {
+	scenariosFileBytes, err := os.ReadFile(scenariosPath)
+	if err != nil {
+		return nil, fmt.Errorf("read scenarios: %w", err)
+	}
+	var scenarios []scenario.Scenario
+	if err = json.Unmarshal(scenariosFileBytes, &scenarios); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", scenariosPath, err)
+	}
+	return scenarios, nil
+}
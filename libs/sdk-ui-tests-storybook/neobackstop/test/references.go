@@ -0,0 +1,171 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/gooddata/gooddata-neobackstop/config"
+	"github.com/gooddata/gooddata-neobackstop/converters"
+	"github.com/gooddata/gooddata-neobackstop/internals"
+)
+
+// referenceFileName is the name neobackstop gives the screenshot of an internal scenario, in both
+// bitmapsReferencePath and bitmapsTestPath. The screenshotter does not export its naming, so
+// checkReferenceNaming compares this copy with the names of a previous run.
+func referenceFileName(configuration config.Config, s internals.Scenario) string {
+	return fmt.Sprintf("%s_%s_%s_0_document_0_%s.png", configuration.Id, s.Browser, s.Id, s.Viewport.Label)
+}
+
+// errReferenceNaming means referenceFileName no longer gives the names of the screenshotter, every
+// reference would look orphaned.
+var errReferenceNaming = errors.New("no reference matches the neobackstop file naming, update referenceFileName")
+
+// orphanedReferences returns the files in bitmapsReferencePath that no internal scenario captures,
+// sorted by name, and the number of references. It fails with errReferenceNaming when there are
+// references, but none of them is expected.
+func orphanedReferences(configuration config.Config, internalScenarios []internals.Scenario) ([]string, int, error) {
+	expected := make(map[string]bool, len(internalScenarios))
+	for _, s := range internalScenarios {
+		expected[referenceFileName(configuration, s)] = true
+	}
+
+	entries, err := os.ReadDir(configuration.BitmapsReferencePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var orphans []string
+	references := 0
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || filepath.Ext(entry.Name()) != ".png" {
+			continue
+		}
+		references++
+		if !expected[entry.Name()] {
+			orphans = append(orphans, entry.Name())
+		}
+	}
+	if references > 0 && len(orphans) == references {
+		return nil, references, errReferenceNaming
+	}
+	slices.Sort(orphans)
+	return orphans, references, nil
+}
+
+// checkReferenceNaming compares referenceFileName with the screenshot names in the results.json of a
+// previous run, when there is one.
+func checkReferenceNaming(configuration config.Config) error {
+	results, err := readResults(filepath.Join(configuration.CiReportPath, resultsFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, r := range results {
+		if r.ScreenshotFileName == nil {
+			continue
+		}
+		if name := referenceFileName(configuration, r.Scenario); name != *r.ScreenshotFileName {
+			return fmt.Errorf("%w: %s was captured as %s", errReferenceNaming, name, *r.ScreenshotFileName)
+		}
+	}
+	return nil
+}
+
+// warnOrphanedReferences prints how many references no internal scenario captures.
+func warnOrphanedReferences(configuration config.Config, internalScenarios []internals.Scenario) {
+	orphans, _, err := orphanedReferences(configuration, internalScenarios)
+	if err != nil {
+		fmt.Println("WARNING: could not look for orphaned references:", err)
+		return
+	}
+	if len(orphans) > 0 {
+		fmt.Printf("WARNING: %d references in %s belong to no scenario, list them with \"references prune\"\n", len(orphans), configuration.BitmapsReferencePath)
+	}
+}
+
+func referencesUsage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: neobackstop references <command> [flags]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	fmt.Fprintf(w, "  %-10s %s\n", "prune", "list references that belong to no scenario, delete them with --apply")
+}
+
+func runReferences(args []string) int {
+	if len(args) == 0 {
+		referencesUsage(os.Stderr)
+		return 2
+	}
+	switch args[0] {
+	case "-h", "-help", "--help", "help":
+		referencesUsage(os.Stdout)
+		return 0
+	case "prune":
+		return runReferencesPrune(args[1:])
+	}
+	fmt.Fprintf(os.Stderr, "unknown references command %q\n\n", args[0])
+	referencesUsage(os.Stderr)
+	return 2
+}
+
+func runReferencesPrune(args []string) int {
+	fs := newFlagSet("references prune", "Lists the files in bitmapsReferencePath that no internal scenario captures, the references of\nrenamed and deleted stories. Nothing is deleted without --apply.")
+	var opts options
+	registerConfigFlag(fs, &opts)
+	registerScenariosFlag(fs, &opts)
+	apply := fs.Bool("apply", false, "delete the orphaned references")
+	force := fs.Bool("force", false, "with --apply, delete even when more than half of the references are orphaned")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+
+	configuration, err := loadConfig(opts.configPath)
+	if err != nil {
+		return fail(err)
+	}
+	scenarios, err := loadScenarios(opts.scenariosPath)
+	if err != nil {
+		return fail(err)
+	}
+	internalScenarios := converters.ScenariosToInternal(configuration.DefaultBrowsers, configuration.Viewports, configuration.RetryCount, scenarios)
+	if len(internalScenarios) == 0 {
+		// every reference would be orphaned
+		return fail(fmt.Errorf("no internal scenarios in %s", opts.scenariosPath))
+	}
+
+	if err = checkReferenceNaming(configuration); err != nil {
+		return fail(err)
+	}
+	orphans, references, err := orphanedReferences(configuration, internalScenarios)
+	if err != nil {
+		return fail(err)
+	}
+
+	for _, name := range orphans {
+		fmt.Println(name)
+	}
+	fmt.Fprintf(os.Stderr, "%d of %d references in %s belong to no scenario\n", len(orphans), references, configuration.BitmapsReferencePath)
+	if !*apply || len(orphans) == 0 {
+		return 0
+	}
+
+	if 2*len(orphans) > references && !*force {
+		// more likely stale scenarios or a wrong config than that many deleted stories
+		return fail(errors.New("more than half of the references would be deleted, check --scenarios and --config or use --force"))
+	}
+	for _, name := range orphans {
+		if err = os.Remove(filepath.Join(configuration.BitmapsReferencePath, name)); err != nil {
+			return fail(err)
+		}
+	}
+	fmt.Fprintln(os.Stderr, "Deleted", len(orphans), "references")
+	return 0
+}
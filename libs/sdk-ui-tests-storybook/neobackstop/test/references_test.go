@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/gooddata/gooddata-neobackstop/config"
+	"github.com/gooddata/gooddata-neobackstop/internals"
+	"github.com/gooddata/gooddata-neobackstop/result"
+)
+
+func referencesConfig(t *testing.T, files ...string) config.Config {
+	t.Helper()
+	dir := t.TempDir()
+	configuration := config.Config{
+		Id:                   "storybook",
+		BitmapsReferencePath: filepath.Join(dir, "reference"),
+		CiReportPath:         filepath.Join(dir, "ci_report"),
+	}
+	for _, path := range []string{configuration.BitmapsReferencePath, configuration.CiReportPath} {
+		if err := os.Mkdir(path, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range files {
+		if err := os.WriteFile(filepath.Join(configuration.BitmapsReferencePath, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return configuration
+}
+
+var referenceScenario = internals.Scenario{Id: "12_UI_Kit_DialogList_DialogList_-_themed", Browser: "chromium", Viewport: config.Viewport{Label: "desktop"}}
+
+func TestOrphanedReferences(t *testing.T) {
+	expected := "storybook_chromium_12_UI_Kit_DialogList_DialogList_-_themed_0_document_0_desktop.png"
+	configuration := referencesConfig(t, expected,
+		"storybook_chromium_12_UI_Kit_DialogList_DialogList_-_themed_0_document_0_desktop copy.png",
+		"storybook_chromium_deleted_story_0_document_0_desktop.png",
+		"notes.txt",
+	)
+	if name := referenceFileName(configuration, referenceScenario); name != expected {
+		t.Fatalf("referenceFileName = %s, want %s", name, expected)
+	}
+
+	orphans, references, err := orphanedReferences(configuration, []internals.Scenario{referenceScenario})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"storybook_chromium_12_UI_Kit_DialogList_DialogList_-_themed_0_document_0_desktop copy.png",
+		"storybook_chromium_deleted_story_0_document_0_desktop.png",
+	}
+	if !slices.Equal(orphans, want) || references != 3 {
+		t.Errorf("orphans = %v of %d references, want %v of 3", orphans, references, want)
+	}
+}
+
+func TestOrphanedReferencesRefusesUnknownNaming(t *testing.T) {
+	configuration := referencesConfig(t, "storybook_chromium_desktop_12_UI_Kit_DialogList_DialogList_-_themed.png")
+	if _, _, err := orphanedReferences(configuration, []internals.Scenario{referenceScenario}); !errors.Is(err, errReferenceNaming) {
+		t.Fatalf("error %v, want errReferenceNaming", err)
+	}
+
+	// without references there is nothing to prune
+	if _, _, err := orphanedReferences(referencesConfig(t), []internals.Scenario{referenceScenario}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckReferenceNaming(t *testing.T) {
+	configuration := referencesConfig(t)
+	if err := checkReferenceNaming(configuration); err != nil {
+		t.Fatalf("without results.json: %v", err)
+	}
+
+	writeResultsFile := func(fileName string) {
+		results := []scenarioResult{{Result: result.Result{Scenario: referenceScenario, ScreenshotFileName: &fileName}, Status: statusNew}}
+		content, err := json.Marshal(results)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = os.WriteFile(filepath.Join(configuration.CiReportPath, resultsFileName), content, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	writeResultsFile(referenceFileName(configuration, referenceScenario))
+	if err := checkReferenceNaming(configuration); err != nil {
+		t.Fatal(err)
+	}
+	writeResultsFile("storybook_chromium_12_UI_Kit_DialogList_DialogList_-_themed_desktop.png")
+	if err := checkReferenceNaming(configuration); !errors.Is(err, errReferenceNaming) {
+		t.Fatalf("error %v, want errReferenceNaming", err)
+	}
+}
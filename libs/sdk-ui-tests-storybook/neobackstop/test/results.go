@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/gooddata/gooddata-neobackstop/comparer"
+	"github.com/gooddata/gooddata-neobackstop/config"
+	"github.com/gooddata/gooddata-neobackstop/html_report"
+	"github.com/gooddata/gooddata-neobackstop/result"
+	"github.com/gooddata/gooddata-neobackstop/screenshotter"
+	"github.com/gooddata/gooddata-neobackstop/utils"
+)
+
+const resultsFileName = "results.json"
+
+// scenarioResult is a result as written to results.json, failed results carry the trace.
+type scenarioResult struct {
+	result.Result
+	Status status `json:"status"`
+	// DurationMs is how long the capture took, shards are balanced by it
+	DurationMs int64 `json:"durationMs,omitempty"`
+	// CompareMs is how long the comparison with the reference took
+	CompareMs           int64       `json:"compareMs,omitempty"`
+	TestDimensions      *dimensions `json:"testDimensions,omitempty"`
+	ReferenceDimensions *dimensions `json:"referenceDimensions,omitempty"`
+	// Attempts are every capture of the scenario, the test run, the retries and the re-verifications
+	Attempts  []captureAttempt `json:"attempts,omitempty"`
+	Stability stability        `json:"stability,omitempty"`
+	Trace     *scenarioTrace   `json:"trace,omitempty"`
+}
+
+// buildResults turns comparisons and failed captures into the results written to results.json. With
+// requireSameDimensions a screenshot that changed size fails even when its pixels are within the
+// threshold.
+func buildResults(configuration config.Config, requireSameDimensions bool, compareResults []comparer.Result, unsuccessfulCaptures []screenshotter.Result, captureDurations map[string]time.Duration, compareDurations map[string]time.Duration) []scenarioResult {
+	results := make([]scenarioResult, 0, len(compareResults)+len(unsuccessfulCaptures))
+
+	for _, compareResult := range compareResults {
+		// we assume that if we could compare, the screenshot job was successful
+		r := scenarioResult{Result: result.Result{
+			Scenario:           *compareResult.ScreenshotterResult.Scenario,
+			ScreenshotFileName: compareResult.ScreenshotterResult.FileName,
+		}}
+
+		if compareResult.HasReference {
+			r.ReferenceFileName = compareResult.ScreenshotterResult.FileName
+			r.MatchesReference = &compareResult.MatchesReference
+
+			if !compareResult.MatchesReference {
+				// diff will exist
+				diffFileName := "diff_" + *compareResult.ScreenshotterResult.FileName
+				r.DiffFileName = &diffFileName
+			}
+
+			if compareResult.MismatchPercentage != nil {
+				// has mismatch value (which it should have in this case)
+				r.MisMatchPercentage = compareResult.MismatchPercentage
+			}
+		} else {
+			// no reference, there will be an error
+			r.Error = compareResult.Error
+		}
+
+		measureDimensions(configuration.BitmapsTestPath, configuration.BitmapsReferencePath, &r)
+		r.Status = classify(r)
+		if r.Status == statusPass && requireSameDimensions && !r.sameDimensions() {
+			r.Status = statusFail
+		}
+		r.DurationMs = captureDurations[scenarioKey(r.Scenario)].Milliseconds()
+		r.CompareMs = compareDurations[scenarioKey(r.Scenario)].Milliseconds()
+		r.Attempts = []captureAttempt{attemptOf(r)}
+		results = append(results, r)
+	}
+
+	for _, unsuccessfulCapture := range unsuccessfulCaptures {
+		// process unsuccessful captures
+		r := scenarioResult{
+			Result: result.Result{
+				Scenario: *unsuccessfulCapture.Scenario,
+				Error:    unsuccessfulCapture.Error,
+			},
+			Status:     statusError,
+			DurationMs: captureDurations[scenarioKey(*unsuccessfulCapture.Scenario)].Milliseconds(),
+		}
+		r.Attempts = []captureAttempt{attemptOf(r)}
+		results = append(results, r)
+	}
+
+	return results
+}
+
+// writeReports writes results.json, junit.xml, summary.md, flaky-report.json and the HTML report.
+func writeReports(configuration config.Config, results []scenarioResult, opts *reportOptions) error {
+	if err := writeResults(configuration, results); err != nil {
+		return err
+	}
+	if err := writeJunit(configuration, results, opts.runner); err != nil {
+		return err
+	}
+	if err := writeSummary(configuration, results, opts); err != nil {
+		return err
+	}
+	if err := writeFlakyReport(configuration, results); err != nil {
+		return err
+	}
+	return writeHtmlReport(configuration, results, opts.runner.RequireSameDimensions)
+}
+
+func writeResults(configuration config.Config, results []scenarioResult) error {
+	jsonResults, err := json.Marshal(results)
+	if err != nil {
+		return err
+	}
+
+	if _, err = os.Stat(configuration.CiReportPath); os.IsNotExist(err) {
+		// CiReportPath does not exist
+		if err = os.Mkdir(configuration.CiReportPath, 0777); err != nil {
+			return err
+		}
+	}
+
+	if err = os.WriteFile(filepath.Join(configuration.CiReportPath, resultsFileName), jsonResults, 0755); err != nil {
+		return fmt.Errorf("error writing file: %w", err)
+	}
+	return nil
+}
+
+func readResults(resultsPath string) ([]scenarioResult, error) {
+	resultsBytes, err := os.ReadFile(resultsPath)
+	if err != nil {
+		return nil, fmt.Errorf("read results: %w", err)
+	}
+	var results []scenarioResult
+	if err = json.Unmarshal(resultsBytes, &results); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", resultsPath, err)
+	}
+	for i := range results {
+		if results[i].Status == "" {
+			// written before results had a status
+			results[i].Status = classify(results[i])
+		}
+	}
+	return results, nil
+}
+
+func writeHtmlReport(configuration config.Config, results []scenarioResult, requireSameDimensions bool) error {
+	htmlReportTests := make([]html_report.Test, 0)
+	for _, r := range results {
+		if r.Status == statusPass && configuration.HtmlReport.ShowSuccessfulTests {
+			// successful test, but do not want it in results
+			continue
+		}
+
+		pair := html_report.Pair{
+			Label:                 r.Scenario.Label,
+			RequireSameDimensions: requireSameDimensions,
+			Url:                   r.Scenario.Url,
+			ViewportLabel:         r.Scenario.Viewport.Label,
+		}
+
+		if r.ReferenceFileName != nil {
+			pair.Reference = "../" + configuration.BitmapsReferencePath + "/" + *r.ReferenceFileName
+		}
+
+		if r.ScreenshotFileName != nil {
+			pair.Test = "../" + configuration.BitmapsTestPath + "/" + *r.ScreenshotFileName
+			pair.FileName = *r.ScreenshotFileName
+		}
+
+		if r.Scenario.MisMatchThreshold != nil {
+			pair.MisMatchThreshold = *r.Scenario.MisMatchThreshold
+		}
+
+		if r.Error != nil {
+			pair.EngineErrorMsg = r.Error
+		}
+
+		if r.DiffFileName != nil || !r.sameDimensions() {
+			diff := html_report.Diff{
+				IsSameDimensions: r.sameDimensions(),
+				AnalysisTime:     int(r.CompareMs),
+			}
+			if !r.sameDimensions() {
+				diff.DimensionDifference = html_report.DimensionDifference{
+					Width:  r.TestDimensions.Width - r.ReferenceDimensions.Width,
+					Height: r.TestDimensions.Height - r.ReferenceDimensions.Height,
+				}
+			}
+
+			if r.MisMatchPercentage != nil {
+				diff.MisMatchPercentage = strconv.FormatFloat(*r.MisMatchPercentage, 'f', -1, 64)
+			}
+
+			pair.Diff = &diff
+			if r.DiffFileName != nil {
+				diffFilePath := "../" + configuration.BitmapsTestPath + "/" + *r.DiffFileName
+				pair.DiffImage = &diffFilePath
+			}
+		}
+
+		htmlReportTests = append(htmlReportTests, html_report.Test{
+			Pair:   pair,
+			Status: r.Status.htmlStatus(),
+		})
+	}
+
+	// generate html report
+	htmlReport := html_report.Result{
+		TestSuite: "BackstopJS",
+		Tests:     htmlReportTests,
+		Id:        "storybook",
+	}
+
+	htmlReportJson, err := json.Marshal(htmlReport)
+	if err != nil {
+		return err
+	}
+
+	if err = utils.CopyDir("./html_report_assets", configuration.HtmlReport.Path); err != nil {
+		return err
+	}
+
+	fileContents := "report(" + string(htmlReportJson) + ");"
+	if err = os.WriteFile(configuration.HtmlReport.Path+"/config.js", []byte(fileContents), 0755); err != nil {
+		return fmt.Errorf("error writing file: %w", err)
+	}
+	return nil
+}
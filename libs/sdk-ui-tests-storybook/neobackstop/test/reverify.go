@@ -0,0 +1,212 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/gooddata/gooddata-neobackstop/config"
+	"github.com/gooddata/gooddata-neobackstop/internals"
+	"github.com/gooddata/gooddata-neobackstop/screenshotter"
+
+	"github.com/playwright-community/playwright-go"
+)
+
+const flakyReportFileName = "flaky-report.json"
+
+// stability classifies a result that was captured more than once by its attempts.
+type stability string
+
+const (
+	// failed with the same status on every attempt
+	stabilityDeterministic stability = "deterministic"
+	// an attempt passed or the attempts failed with different statuses, the scenario needs a better
+	// ready selector or delay
+	stabilityFlaky stability = "flaky"
+)
+
+// captureAttempt is one capture and comparison of an internal scenario, the first is the test run
+// itself.
+type captureAttempt struct {
+	Status             status   `json:"status"`
+	MisMatchPercentage *float64 `json:"misMatchPercentage,omitempty"`
+	Error              *string  `json:"error,omitempty"`
+	DurationMs         int64    `json:"durationMs,omitempty"`
+	// Dir is the directory in bitmapsTestPath the attempt was captured into, empty for the test run
+	Dir   string         `json:"dir,omitempty"`
+	Trace *scenarioTrace `json:"trace,omitempty"`
+}
+
+func attemptOf(r scenarioResult) captureAttempt {
+	return captureAttempt{
+		Status:             r.Status,
+		MisMatchPercentage: r.MisMatchPercentage,
+		Error:              r.Error,
+		DurationMs:         r.DurationMs,
+	}
+}
+
+// stabilityOf classifies the attempts of a result. Mismatch percentages are not compared, rendering
+// noise moves them on every capture.
+func stabilityOf(attempts []captureAttempt) stability {
+	for _, a := range attempts {
+		if a.Status == statusPass || a.Status != attempts[0].Status {
+			return stabilityFlaky
+		}
+	}
+	return stabilityDeterministic
+}
+
+// needsAttempt reports whether a result is captured again: it failed and none of its attempts passed.
+func needsAttempt(r scenarioResult) bool {
+	if r.Status != statusFail && r.Status != statusError {
+		return false
+	}
+	return !slices.ContainsFunc(r.Attempts, func(a captureAttempt) bool { return a.Status == statusPass })
+}
+
+// recapture captures the failed and errored internal scenarios again, retries times (retryCount in
+// config.json) and then reverifyTimes more, each attempt into its own directory in bitmapsTestPath.
+// The runner retries instead of neobackstop, so every attempt is recorded in the result; a scenario is
+// not captured again once an attempt passed. Results captured more than once get their stability,
+// flaky ones the flaky status.
+func recapture(pw *playwright.Playwright, configuration config.Config, requireSameDimensions bool, results []scenarioResult, internalScenarios []internals.Scenario, retries int, reverifyTimes int) error {
+	var dirs []string
+	for attempt := 1; attempt <= retries; attempt++ {
+		dirs = append(dirs, fmt.Sprintf("retry-%d", attempt))
+	}
+	for attempt := 1; attempt <= reverifyTimes; attempt++ {
+		dirs = append(dirs, fmt.Sprintf("reverify-%d", attempt))
+	}
+	for _, dir := range dirs {
+		captured, err := captureAgain(pw, configuration, requireSameDimensions, results, internalScenarios, dir)
+		if err != nil {
+			return err
+		}
+		if !captured {
+			break
+		}
+	}
+
+	if flaky, deterministic := classifyAttempts(results); flaky+deterministic > 0 {
+		fmt.Println("Captured again:", flaky, "flaky and", deterministic, "deterministic failures")
+	}
+	return nil
+}
+
+// classifyAttempts sets the stability of the results captured more than once, flaky ones get the flaky
+// status.
+func classifyAttempts(results []scenarioResult) (int, int) {
+	flaky, deterministic := 0, 0
+	for i := range results {
+		if len(results[i].Attempts) < 2 {
+			continue
+		}
+		results[i].Stability = stabilityOf(results[i].Attempts)
+		if results[i].Stability == stabilityFlaky {
+			results[i].Status = statusFlaky
+			flaky++
+		} else {
+			deterministic++
+		}
+	}
+	return flaky, deterministic
+}
+
+// captureAgain captures the results that need an attempt into dir in bitmapsTestPath and appends the
+// attempts. The scenario urls are tagged with the attempt, so serve traces every attempt on its own.
+// It reports false when no result needed an attempt.
+func captureAgain(pw *playwright.Playwright, configuration config.Config, requireSameDimensions bool, results []scenarioResult, internalScenarios []internals.Scenario, dir string) (bool, error) {
+	pending := map[string]int{}
+	for i := range results {
+		if needsAttempt(results[i]) {
+			pending[scenarioKey(results[i].Scenario)] = i
+		}
+	}
+
+	var scenarios []internals.Scenario
+	for _, s := range internalScenarios {
+		if _, ok := pending[scenarioKey(s)]; ok {
+			s.Url = tagScenarioUrl(s.Url, attemptKey(s, dir))
+			scenarios = append(scenarios, s)
+		}
+	}
+	if len(scenarios) == 0 {
+		return false, nil
+	}
+	fmt.Println("Capturing", len(scenarios), "failed internal scenarios again into", dir)
+
+	attemptConfiguration := configuration
+	attemptConfiguration.BitmapsTestPath = filepath.Join(configuration.BitmapsTestPath, dir)
+
+	captures, captureDurations, err := capture(pw, "test", attemptConfiguration.BitmapsTestPath, attemptConfiguration, scenarios)
+	if err != nil {
+		return false, err
+	}
+	var unsuccessfulCaptures, successfulCaptures []screenshotter.Result
+	for _, screenshotterResult := range captures {
+		if screenshotterResult.Success {
+			successfulCaptures = append(successfulCaptures, screenshotterResult)
+		} else {
+			unsuccessfulCaptures = append(unsuccessfulCaptures, screenshotterResult)
+		}
+	}
+	compareResults, compareDurations := compare(attemptConfiguration, successfulCaptures)
+
+	for _, r := range buildResults(attemptConfiguration, requireSameDimensions, compareResults, unsuccessfulCaptures, captureDurations, compareDurations) {
+		i := pending[scenarioKey(r.Scenario)]
+		attempt := attemptOf(r)
+		attempt.Dir = dir
+		results[i].Attempts = append(results[i].Attempts, attempt)
+	}
+	return true, nil
+}
+
+type flakyScenario struct {
+	Id       string           `json:"id"`
+	Label    string           `json:"label"`
+	Browser  string           `json:"browser"`
+	Viewport string           `json:"viewport"`
+	Url      string           `json:"url"`
+	Attempts []captureAttempt `json:"attempts"`
+}
+
+type flakyReport struct {
+	Count     int             `json:"count"`
+	Scenarios []flakyScenario `json:"scenarios"`
+}
+
+// writeFlakyReport writes flaky-report.json next to results.json, the scenarios whose attempts
+// disagreed.
+func writeFlakyReport(configuration config.Config, results []scenarioResult) error {
+	report := flakyReport{Scenarios: []flakyScenario{}}
+	for _, r := range results {
+		if r.Stability != stabilityFlaky {
+			continue
+		}
+		report.Scenarios = append(report.Scenarios, flakyScenario{
+			Id:       r.Scenario.Id,
+			Label:    r.Scenario.Label,
+			Browser:  string(r.Scenario.Browser),
+			Viewport: r.Scenario.Viewport.Label,
+			Url:      r.Scenario.Url,
+			Attempts: r.Attempts,
+		})
+	}
+	slices.SortFunc(report.Scenarios, func(a, b flakyScenario) int {
+		return cmp.Or(cmp.Compare(a.Label, b.Label), cmp.Compare(a.Browser, b.Browser), cmp.Compare(a.Viewport, b.Viewport))
+	})
+	report.Count = len(report.Scenarios)
+
+	reportJson, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = os.WriteFile(filepath.Join(configuration.CiReportPath, flakyReportFileName), reportJson, 0755); err != nil {
+		return fmt.Errorf("error writing file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import "testing"
+
+func attempts(statuses ...status) []captureAttempt {
+	out := make([]captureAttempt, 0, len(statuses))
+	for i, s := range statuses {
+		// the mismatch changes on every capture
+		mismatch := 1.5 + float64(i)
+		out = append(out, captureAttempt{Status: s, MisMatchPercentage: &mismatch})
+	}
+	return out
+}
+
+func TestStabilityOf(t *testing.T) {
+	tests := []struct {
+		name     string
+		attempts []captureAttempt
+		want     stability
+	}{
+		{name: "failed every time with a different mismatch", attempts: attempts(statusFail, statusFail, statusFail), want: stabilityDeterministic},
+		{name: "errored every time", attempts: attempts(statusError, statusError), want: stabilityDeterministic},
+		{name: "passed on a retry", attempts: attempts(statusFail, statusPass), want: stabilityFlaky},
+		{name: "failed and errored", attempts: attempts(statusError, statusFail), want: stabilityFlaky},
+		{name: "passed after errors", attempts: attempts(statusError, statusError, statusPass), want: stabilityFlaky},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := stabilityOf(tt.attempts); got != tt.want {
+				t.Errorf("stabilityOf = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNeedsAttempt(t *testing.T) {
+	tests := []struct {
+		name   string
+		result scenarioResult
+		want   bool
+	}{
+		{name: "failed", result: scenarioResult{Status: statusFail, Attempts: attempts(statusFail)}, want: true},
+		{name: "errored", result: scenarioResult{Status: statusError, Attempts: attempts(statusError, statusError)}, want: true},
+		{name: "passed on a retry", result: scenarioResult{Status: statusFail, Attempts: attempts(statusFail, statusPass)}},
+		{name: "passed", result: scenarioResult{Status: statusPass, Attempts: attempts(statusPass)}},
+		{name: "new", result: scenarioResult{Status: statusNew, Attempts: attempts(statusNew)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := needsAttempt(tt.result); got != tt.want {
+				t.Errorf("needsAttempt = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAttemptKey(t *testing.T) {
+	s := referenceScenario
+	if got := attemptKey(s, ""); got != scenarioKey(s) {
+		t.Errorf("test run key = %s, want the scenarioKey", got)
+	}
+	retry, reverify := attemptKey(s, "retry-1"), attemptKey(s, "reverify-1")
+	if retry == scenarioKey(s) || retry == reverify {
+		t.Errorf("attempt keys %s and %s are not distinct", retry, reverify)
+	}
+	// serve sees the key in the url of the attempt
+	if got := tagScenarioUrl("http://ag-grid.com:8080/iframe.html?id=a&neobackstopScenario="+scenarioKey(s), retry); got != "http://ag-grid.com:8080/iframe.html?id=a&neobackstopScenario="+retry {
+		t.Errorf("tagged url = %s", got)
+	}
+}
+
+func TestPassOnRetryPassesWithShippedConfig(t *testing.T) {
+	runner, err := loadRunnerConfig("../config.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	results := []scenarioResult{
+		{Status: statusPass, Attempts: attempts(statusPass)},
+		{Status: statusFail, Attempts: attempts(statusFail, statusPass)},
+	}
+	classifyAttempts(results)
+	if results[1].Status != statusFlaky || results[1].Stability != stabilityFlaky {
+		t.Fatalf("pass on retry = %s, %s, want flaky", results[1].Status, results[1].Stability)
+	}
+	// runTest exits with 1 when a result fails
+	if failing := countFailing(results, runner); failing != 0 {
+		t.Errorf("%d failing with the shipped config.json, a pass on retry must not fail the run", failing)
+	}
+	if failing := countFailing(results, runnerConfig{FailOnFlaky: true}); failing != 1 {
+		t.Errorf("%d failing with failOnFlaky, want 1", failing)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gooddata/gooddata-neobackstop/internals"
+)
+
+// shard is the --shard=i/n flag, the 1-based index of this run out of count runs.
+type shard struct {
+	index int
+	count int
+}
+
+func (s *shard) String() string {
+	if s.count == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d/%d", s.index, s.count)
+}
+
+func (s *shard) Set(value string) error {
+	index, count, ok := strings.Cut(value, "/")
+	if !ok {
+		return errors.New("expected i/n")
+	}
+	var err error
+	if s.index, err = strconv.Atoi(index); err != nil {
+		return fmt.Errorf("invalid shard index %q", index)
+	}
+	if s.count, err = strconv.Atoi(count); err != nil {
+		return fmt.Errorf("invalid shard count %q", count)
+	}
+	if s.count < 1 || s.index < 1 || s.index > s.count {
+		return fmt.Errorf("shard %s is out of range, expected 1 <= i <= n", value)
+	}
+	return nil
+}
+
+func (s *shard) active() bool {
+	return s.count > 0
+}
+
+// loadTimings reads the capture durations by scenarioKey from the results.json of a previous run.
+func loadTimings(resultsPath string) (map[string]time.Duration, error) {
+	results, err := readResults(resultsPath)
+	if err != nil {
+		return nil, err
+	}
+	timings := make(map[string]time.Duration, len(results))
+	for _, r := range results {
+		if r.DurationMs > 0 {
+			timings[scenarioKey(r.Scenario)] = time.Duration(r.DurationMs) * time.Millisecond
+		}
+	}
+	return timings, nil
+}
+
+// apply returns the internal scenarios of this shard. Every shard computes the same partition from the
+// same scenarios and timings: the longest scenarios are assigned first, each to the least loaded shard.
+// Scenarios without timing count as the mean of the known ones, without any timings the shards get the
+// same number of scenarios.
+func (s *shard) apply(internalScenarios []internals.Scenario, timings map[string]time.Duration) []internals.Scenario {
+	var known time.Duration
+	numKnown := 0
+	for _, is := range internalScenarios {
+		if d, ok := timings[scenarioKey(is)]; ok {
+			known += d
+			numKnown++
+		}
+	}
+	unknown := time.Second
+	if numKnown > 0 {
+		unknown = known / time.Duration(numKnown)
+	}
+	weight := func(is internals.Scenario) time.Duration {
+		if d, ok := timings[scenarioKey(is)]; ok {
+			return d
+		}
+		return unknown
+	}
+
+	order := slices.Clone(internalScenarios)
+	slices.SortFunc(order, func(a, b internals.Scenario) int {
+		return cmp.Or(cmp.Compare(weight(b), weight(a)), cmp.Compare(scenarioKey(a), scenarioKey(b)))
+	})
+
+	loads := make([]time.Duration, s.count)
+	selected := map[string]bool{}
+	for _, is := range order {
+		// the first least loaded shard, so ties are broken the same way everywhere
+		target := 0
+		for i := range loads {
+			if loads[i] < loads[target] {
+				target = i
+			}
+		}
+		loads[target] += weight(is)
+		if target == s.index-1 {
+			selected[scenarioKey(is)] = true
+		}
+	}
+
+	// keep the original order for the screenshotter
+	return slices.DeleteFunc(slices.Clone(internalScenarios), func(is internals.Scenario) bool {
+		return !selected[scenarioKey(is)]
+	})
+}
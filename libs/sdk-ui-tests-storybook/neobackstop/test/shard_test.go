@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gooddata/gooddata-neobackstop/config"
+	"github.com/gooddata/gooddata-neobackstop/internals"
+)
+
+func TestShardSet(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    shard
+		wantErr bool
+	}{
+		{value: "1/1", want: shard{index: 1, count: 1}},
+		{value: "2/3", want: shard{index: 2, count: 3}},
+		{value: "3", wantErr: true},
+		{value: "0/3", wantErr: true},
+		{value: "4/3", wantErr: true},
+		{value: "1/0", wantErr: true},
+		{value: "a/3", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			var s shard
+			err := s.Set(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error %v, want error %t", err, tt.wantErr)
+			}
+			if err == nil && s != tt.want {
+				t.Fatalf("shard = %+v, want %+v", s, tt.want)
+			}
+		})
+	}
+}
+
+func shardScenarios(n int) []internals.Scenario {
+	scenarios := make([]internals.Scenario, 0, n)
+	for i := range n {
+		scenarios = append(scenarios, internals.Scenario{
+			Id:       fmt.Sprintf("story-%02d", i),
+			Browser:  "chromium",
+			Viewport: config.Viewport{Label: "desktop"},
+		})
+	}
+	return scenarios
+}
+
+func TestShardApplyPartitions(t *testing.T) {
+	scenarios := shardScenarios(10)
+	timings := map[string]time.Duration{}
+	for i, s := range scenarios[:6] {
+		timings[scenarioKey(s)] = time.Duration(i+1) * time.Second
+	}
+
+	seen := map[string]int{}
+	for index := 1; index <= 3; index++ {
+		s := shard{index: index, count: 3}
+		selected := s.apply(scenarios, timings)
+		// the screenshotter gets the scenarios in their original order
+		if !slices.IsSortedFunc(selected, func(a, b internals.Scenario) int { return strings.Compare(a.Id, b.Id) }) {
+			t.Errorf("shard %d reordered the scenarios: %v", index, selected)
+		}
+		for _, is := range selected {
+			seen[scenarioKey(is)]++
+		}
+		if again := s.apply(scenarios, timings); !slices.EqualFunc(selected, again, func(a, b internals.Scenario) bool { return a.Id == b.Id }) {
+			t.Errorf("shard %d is not deterministic", index)
+		}
+	}
+	for _, is := range scenarios {
+		if seen[scenarioKey(is)] != 1 {
+			t.Errorf("%s is in %d shards, want 1", scenarioKey(is), seen[scenarioKey(is)])
+		}
+	}
+}
+
+func TestShardApplyBalancesTimings(t *testing.T) {
+	scenarios := shardScenarios(4)
+	timings := map[string]time.Duration{
+		scenarioKey(scenarios[0]): 9 * time.Second,
+		scenarioKey(scenarios[1]): 5 * time.Second,
+		scenarioKey(scenarios[2]): 4 * time.Second,
+	}
+	// the unknown scenario counts as the mean, 6s: shard 1 gets 9s + 4s, shard 2 gets 6s + 5s
+	ids := func(selected []internals.Scenario) []string {
+		ids := make([]string, 0, len(selected))
+		for _, is := range selected {
+			ids = append(ids, is.Id)
+		}
+		return ids
+	}
+	if first := ids((&shard{index: 1, count: 2}).apply(scenarios, timings)); !slices.Equal(first, []string{"story-00", "story-02"}) {
+		t.Errorf("shard 1 = %v, want story-00 and story-02", first)
+	}
+	if second := ids((&shard{index: 2, count: 2}).apply(scenarios, timings)); !slices.Equal(second, []string{"story-01", "story-03"}) {
+		t.Errorf("shard 2 = %v, want story-01 and story-03", second)
+	}
+
+	// without timings the shards get the same number of scenarios
+	for index := 1; index <= 2; index++ {
+		if selected := (&shard{index: index, count: 2}).apply(scenarios, nil); len(selected) != 2 {
+			t.Errorf("shard %d without timings has %d scenarios, want 2", index, len(selected))
+		}
+	}
+}
+
+func TestJobTimerKeepsJobsFinishedBeforePickUp(t *testing.T) {
+	timer := newJobTimer(1)
+	timer.sending("a")
+	// the worker finished before the sender recorded the pick up
+	timer.finished("a")
+	timer.picked("a")
+	if _, ok := timer.durations["a"]; !ok {
+		t.Fatal("duration of a job that finished before its pick up was recorded is dropped")
+	}
+
+	timer.sending("b")
+	timer.picked("b")
+	timer.finished("b")
+	if _, ok := timer.durations["b"]; !ok {
+		t.Fatal("duration dropped")
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+// status is the outcome of an internal scenario. It is computed once when the results are built and
+// stored in results.json, the reports only read it.
+type status string
+
+const (
+	statusPass status = "pass"
+	// the screenshot differs from the reference
+	statusFail status = "fail"
+	// the screenshot was captured, but there is no reference yet
+	statusNew status = "new"
+	// the scenario could not be captured or compared
+	statusError status = "error"
+	// the scenario failed, but an attempt passed or the attempts failed differently
+	statusFlaky status = "flaky"
+)
+
+// fails reports whether a scenario with the status fails the run. New scenarios fail only with
+// newFails in config.json, flaky ones only with failOnFlaky.
+func (s status) fails(runner runnerConfig) bool {
+	switch s {
+	case statusFail, statusError:
+		return true
+	case statusNew:
+		return runner.NewFails
+	case statusFlaky:
+		return runner.FailOnFlaky
+	default:
+		return false
+	}
+}
+
+// htmlStatus is the status in the HTML report, which only knows passed and failed tests. The other
+// reports keep the detailed status.
+func (s status) htmlStatus() string {
+	if s == statusPass {
+		return "pass"
+	}
+	return "fail"
+}
+
+// classify computes the status of a result from its capture and comparison.
+func classify(r scenarioResult) status {
+	switch {
+	case r.ScreenshotFileName != nil && r.ReferenceFileName == nil:
+		return statusNew
+	case r.Error != nil || r.ScreenshotFileName == nil:
+		return statusError
+	case r.MatchesReference == nil || !*r.MatchesReference:
+		return statusFail
+	default:
+		return statusPass
+	}
+}
+
+// countFailing counts the results that fail the run.
+func countFailing(results []scenarioResult, runner runnerConfig) int {
+	failing := 0
+	for _, r := range results {
+		if r.Status.fails(runner) {
+			failing++
+		}
+	}
+	return failing
+}
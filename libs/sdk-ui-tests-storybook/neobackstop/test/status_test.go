@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/gooddata/gooddata-neobackstop/result"
+)
+
+func TestClassify(t *testing.T) {
+	fileName := "scenario.png"
+	message := "timeout"
+	matches, differs := true, false
+	tests := []struct {
+		name   string
+		result result.Result
+		want   status
+	}{
+		{name: "matches", result: result.Result{ScreenshotFileName: &fileName, ReferenceFileName: &fileName, MatchesReference: &matches}, want: statusPass},
+		{name: "differs", result: result.Result{ScreenshotFileName: &fileName, ReferenceFileName: &fileName, MatchesReference: &differs}, want: statusFail},
+		{name: "not compared", result: result.Result{ScreenshotFileName: &fileName, ReferenceFileName: &fileName}, want: statusFail},
+		{name: "no reference", result: result.Result{ScreenshotFileName: &fileName, Error: &message}, want: statusNew},
+		{name: "not captured", result: result.Result{Error: &message}, want: statusError},
+		{name: "not captured without error", result: result.Result{}, want: statusError},
+		{name: "compare error", result: result.Result{ScreenshotFileName: &fileName, ReferenceFileName: &fileName, Error: &message}, want: statusError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := classify(scenarioResult{Result: tt.result}); got != tt.want {
+				t.Errorf("classify = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCountFailing(t *testing.T) {
+	results := []scenarioResult{
+		{Status: statusPass},
+		{Status: statusFail},
+		{Status: statusError},
+		{Status: statusNew},
+		{Status: statusFlaky},
+	}
+	if got := countFailing(results, runnerConfig{}); got != 2 {
+		t.Errorf("countFailing = %d, want 2", got)
+	}
+	if got := countFailing(results, runnerConfig{NewFails: true}); got != 3 {
+		t.Errorf("countFailing with newFails = %d, want 3", got)
+	}
+	if got := countFailing(results, runnerConfig{FailOnFlaky: true}); got != 3 {
+		t.Errorf("countFailing with failOnFlaky = %d, want 3", got)
+	}
+}
+
+func TestHtmlStatus(t *testing.T) {
+	for s, want := range map[status]string{statusPass: "pass", statusFail: "fail", statusNew: "fail", statusError: "fail", statusFlaky: "fail"} {
+		if got := s.htmlStatus(); got != want {
+			t.Errorf("%s in the HTML report = %s, want %s", s, got, want)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"cmp"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/gooddata/gooddata-neobackstop/config"
+)
+
+const (
+	summaryFileName = "summary.md"
+	// a GitHub comment holds 65536 characters
+	defaultSummaryLimit = 60000
+	// room kept for closing the open section and the truncation note
+	summaryReserve = 512
+	// longer error texts are cut, they are complete in results.json
+	maxSummaryErrorLength = 2000
+)
+
+// reportOptions are the flags of the commands writing the reports.
+type reportOptions struct {
+	summaryLimit int
+	// runner are the keys of config.json only this runner reads
+	runner runnerConfig
+}
+
+func (opts *reportOptions) validate() error {
+	if opts.summaryLimit < 2*summaryReserve {
+		return fmt.Errorf("--summary-limit must be at least %d", 2*summaryReserve)
+	}
+	return nil
+}
+
+// load reads the report settings from config.json.
+func (opts *reportOptions) load(configPath string) error {
+	runner, err := loadRunnerConfig(configPath)
+	if err != nil {
+		return err
+	}
+	opts.runner = runner
+	return nil
+}
+
+func registerReportFlags(fs *flag.FlagSet) *reportOptions {
+	opts := &reportOptions{}
+	fs.IntVar(&opts.summaryLimit, "summary-limit", defaultSummaryLimit, "maximum size of summary.md in bytes, longer tables and error lists are cut")
+	return opts
+}
+
+// summaryWriter builds markdown up to a size limit. Once a line does not fit, the rest of the content
+// is dropped, closing lines are always written.
+type summaryWriter struct {
+	b         strings.Builder
+	limit     int
+	truncated bool
+}
+
+// line writes s and reports whether it fit.
+func (w *summaryWriter) line(s string) bool {
+	if w.truncated {
+		return false
+	}
+	if w.b.Len()+len(s)+1 > w.limit-summaryReserve {
+		w.truncated = true
+		return false
+	}
+	w.b.WriteString(s)
+	w.b.WriteByte('\n')
+	return true
+}
+
+func (w *summaryWriter) closing(s string) {
+	w.b.WriteString(s)
+	w.b.WriteByte('\n')
+}
+
+// markdownCell escapes s for a table cell.
+func markdownCell(s string) string {
+	s = strings.ReplaceAll(s, "|", `\|`)
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// writeSummary writes summary.md next to results.json, short enough to be posted as a pull request
+// comment.
+func writeSummary(configuration config.Config, results []scenarioResult, opts *reportOptions) error {
+	counts := map[status]int{}
+	var failing, flaky, added, errored []scenarioResult
+	for _, r := range results {
+		counts[r.Status]++
+		switch r.Status {
+		case statusFail:
+			failing = append(failing, r)
+		case statusFlaky:
+			flaky = append(flaky, r)
+		case statusNew:
+			added = append(added, r)
+		case statusError:
+			errored = append(errored, r)
+		}
+	}
+
+	// the biggest changes first
+	mismatch := func(r scenarioResult) float64 {
+		if r.MisMatchPercentage == nil {
+			return 0
+		}
+		return *r.MisMatchPercentage
+	}
+	slices.SortStableFunc(failing, func(a, b scenarioResult) int {
+		return cmp.Or(cmp.Compare(mismatch(b), mismatch(a)), cmp.Compare(a.Scenario.Label, b.Scenario.Label))
+	})
+	byLabel := func(a, b scenarioResult) int {
+		return cmp.Compare(a.Scenario.Label, b.Scenario.Label)
+	}
+	slices.SortStableFunc(flaky, byLabel)
+	slices.SortStableFunc(added, byLabel)
+	slices.SortStableFunc(errored, byLabel)
+
+	w := &summaryWriter{limit: opts.summaryLimit}
+	verdict := "passed"
+	if countFailing(results, opts.runner) > 0 {
+		verdict = "failed"
+	}
+	w.line(fmt.Sprintf("## Visual regression %s %s", configuration.Id, verdict))
+	w.line("")
+	w.line("| Pass | Fail | New | Error | Flaky | Total |")
+	w.line("| ---: | ---: | --: | ----: | ----: | ----: |")
+	w.line(fmt.Sprintf("| %d | %d | %d | %d | %d | %d |", counts[statusPass], counts[statusFail], counts[statusNew], counts[statusError], counts[statusFlaky], len(results)))
+
+	scenarioRow := func(r scenarioResult) string {
+		return fmt.Sprintf("| %s | %s | %s | %s |", markdownCell(r.Scenario.Label), markdownCell(storyKind(r.Scenario.Label)), r.Scenario.Browser, markdownCell(r.Scenario.Viewport.Label))
+	}
+
+	if len(failing) > 0 {
+		w.line("")
+		w.line(fmt.Sprintf("### Failing scenarios (%d)", len(failing)))
+		w.line("")
+		w.line("| Mismatch | Scenario | Story kind | Browser | Viewport |")
+		w.line("| -------: | -------- | ---------- | ------- | -------- |")
+		for _, r := range failing {
+			percentage := "-"
+			if r.MisMatchPercentage != nil {
+				percentage = fmt.Sprintf("%.2f%%", *r.MisMatchPercentage)
+			}
+			w.line("| " + percentage + " " + scenarioRow(r))
+		}
+	}
+
+	if len(flaky) > 0 {
+		w.line("")
+		w.line(fmt.Sprintf("### Flaky scenarios (%d)", len(flaky)))
+		w.line("")
+		w.line("| Attempts | Scenario | Story kind | Browser | Viewport |")
+		w.line("| -------- | -------- | ---------- | ------- | -------- |")
+		for _, r := range flaky {
+			statuses := make([]string, 0, len(r.Attempts))
+			for _, a := range r.Attempts {
+				statuses = append(statuses, string(a.Status))
+			}
+			w.line("| " + strings.Join(statuses, ", ") + " " + scenarioRow(r))
+		}
+	}
+
+	if len(added) > 0 {
+		w.line("")
+		if w.line(fmt.Sprintf("<details><summary>New scenarios without reference (%d)</summary>", len(added))) {
+			w.line("")
+			w.line("| Scenario | Story kind | Browser | Viewport |")
+			w.line("| -------- | ---------- | ------- | -------- |")
+			for _, r := range added {
+				w.line(scenarioRow(r))
+			}
+			w.closing("")
+			w.closing("</details>")
+		}
+	}
+
+	if len(errored) > 0 {
+		w.line("")
+		w.line(fmt.Sprintf("### Errors (%d)", len(errored)))
+		for _, r := range errored {
+			text := "scenario could not be captured"
+			if r.Error != nil {
+				text = *r.Error
+			}
+			if len(text) > maxSummaryErrorLength {
+				text = strings.ToValidUTF8(text[:maxSummaryErrorLength], "") + "..."
+			}
+			w.line("")
+			if !w.line(fmt.Sprintf("<details><summary>%s (%s, %s)</summary>", markdownCell(r.Scenario.Label), r.Scenario.Browser, markdownCell(r.Scenario.Viewport.Label))) {
+				break
+			}
+			w.line("")
+			if w.line("```text") {
+				w.line(strings.ReplaceAll(text, "```", "'''"))
+				if w.truncated {
+					// the error did not fit, close its block
+					w.closing("...")
+				}
+				w.closing("```")
+			}
+			w.closing("")
+			w.closing("</details>")
+		}
+	}
+
+	if w.truncated {
+		w.closing("")
+		w.closing(fmt.Sprintf("_The summary is cut at %d bytes, see the HTML report for all results._", opts.summaryLimit))
+	}
+
+	if err := os.WriteFile(filepath.Join(configuration.CiReportPath, summaryFileName), []byte(w.b.String()), 0755); err != nil {
+		return fmt.Errorf("error writing file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gooddata/gooddata-neobackstop/config"
+)
+
+func readSummary(t *testing.T, configuration config.Config, results []scenarioResult, limit int) string {
+	t.Helper()
+	if err := writeSummary(configuration, results, &reportOptions{summaryLimit: limit}); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(filepath.Join(configuration.CiReportPath, summaryFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
+
+func TestWriteSummaryFits(t *testing.T) {
+	configuration := config.Config{Id: "storybook", CiReportPath: t.TempDir()}
+	results := []scenarioResult{reportResult("Kind - passing", statusPass), reportResult("Kind - failing", statusFail)}
+
+	summary := readSummary(t, configuration, results, defaultSummaryLimit)
+	if !strings.HasPrefix(summary, "## Visual regression storybook failed\n") {
+		t.Errorf("summary starts with %q", strings.SplitN(summary, "\n", 2)[0])
+	}
+	if !strings.Contains(summary, "| 1 | 1 | 0 | 0 | 0 | 2 |") || !strings.Contains(summary, "| 3.50% | Kind - failing |") {
+		t.Errorf("summary misses the counts or the failing scenario:\n%s", summary)
+	}
+	if strings.Contains(summary, "The summary is cut") {
+		t.Error("summary that fits is marked as cut")
+	}
+}
+
+func TestWriteSummaryTruncates(t *testing.T) {
+	configuration := config.Config{Id: "storybook", CiReportPath: t.TempDir()}
+	var results []scenarioResult
+	for i := range 200 {
+		results = append(results, reportResult(fmt.Sprintf("Kind - failing %03d", i), statusFail))
+		results = append(results, reportResult(fmt.Sprintf("Kind - added %03d", i), statusNew))
+		errored := reportResult(fmt.Sprintf("Kind - broken %03d", i), statusError)
+		message := strings.Repeat("timeout waiting for selector ```.s-loading``` ", 100)
+		errored.Error = &message
+		results = append(results, errored)
+	}
+
+	for _, limit := range []int{2 * summaryReserve, 4000, 20000, defaultSummaryLimit} {
+		t.Run(fmt.Sprint(limit), func(t *testing.T) {
+			summary := readSummary(t, configuration, results, limit)
+			if len(summary) > limit {
+				t.Errorf("summary has %d bytes, limit %d", len(summary), limit)
+			}
+			if !strings.HasSuffix(summary, fmt.Sprintf("_The summary is cut at %d bytes, see the HTML report for all results._\n", limit)) {
+				t.Errorf("summary does not end with the truncation note:\n%s", summary[max(0, len(summary)-300):])
+			}
+			// every opened block is closed
+			if open, closed := strings.Count(summary, "<details>"), strings.Count(summary, "</details>"); open != closed {
+				t.Errorf("%d details opened, %d closed", open, closed)
+			}
+			if fences := strings.Count(summary, "```"); fences%2 != 0 {
+				t.Errorf("%d code fences, an error block is left open", fences)
+			}
+		})
+	}
+}
+
+func TestReportOptionsValidate(t *testing.T) {
+	if err := (&reportOptions{summaryLimit: 2*summaryReserve - 1}).validate(); err == nil {
+		t.Error("limit below the reserve accepted")
+	}
+	if err := (&reportOptions{summaryLimit: defaultSummaryLimit}).validate(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestWriteSummaryErrorWithoutMessage(t *testing.T) {
+	configuration := config.Config{Id: "storybook", CiReportPath: t.TempDir()}
+	summary := readSummary(t, configuration, []scenarioResult{reportResult("Kind - broken", statusError)}, defaultSummaryLimit)
+	if !strings.Contains(summary, "```text\nscenario could not be captured\n```") {
+		t.Errorf("summary misses the fallback message:\n%s", summary)
+	}
+}
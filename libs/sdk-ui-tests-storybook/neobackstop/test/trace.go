@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gooddata/gooddata-neobackstop/internals"
+)
+
+// scenarioParam tags the scenario url, so serve can attribute the requests of the page (and through
+// their Referer, of its assets) to the internal scenario. See serve/trace.go.
+const scenarioParam = "neobackstopScenario"
+
+type tracedRequest struct {
+	Path       string  `json:"path"`
+	Status     int     `json:"status"`
+	DurationMs float64 `json:"durationMs"`
+}
+
+// scenarioTrace is what serve observed while the scenario was captured.
+type scenarioTrace struct {
+	NotFound []string        `json:"notFound,omitempty"`
+	Slow     []tracedRequest `json:"slow,omitempty"`
+}
+
+// scenarioKey identifies an internal scenario. The id is shared by the internal scenarios of one
+// scenario in different browsers and viewports.
+func scenarioKey(s internals.Scenario) string {
+	return s.Id + "_" + string(s.Browser) + "_" + s.Viewport.Label
+}
+
+// attemptKey is the key the url of an attempt captured into dir is tagged with, the test run itself is
+// tagged with the scenarioKey.
+func attemptKey(s internals.Scenario, dir string) string {
+	if dir == "" {
+		return scenarioKey(s)
+	}
+	return scenarioKey(s) + "_" + dir
+}
+
+func tagScenarioUrl(scenarioUrl string, key string) string {
+	u, err := url.Parse(scenarioUrl)
+	if err != nil {
+		return scenarioUrl
+	}
+	query := u.Query()
+	query.Set(scenarioParam, key)
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// fetchScenarioTrace asks the server the scenario was loaded from for the missing and slow assets.
+func fetchScenarioTrace(client *http.Client, scenarioUrl string, key string) (*scenarioTrace, error) {
+	u, err := url.Parse(scenarioUrl)
+	if err != nil {
+		return nil, err
+	}
+	traceUrl := url.URL{Scheme: u.Scheme, Host: u.Host, Path: "/__trace", RawQuery: url.Values{"scenario": {key}}.Encode()}
+
+	resp, err := client.Get(traceUrl.String())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		// nothing was requested for the scenario
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", traceUrl.String(), resp.Status)
+	}
+
+	var trace scenarioTrace
+	if err = json.NewDecoder(resp.Body).Decode(&trace); err != nil {
+		return nil, fmt.Errorf("GET %s: %w", traceUrl.String(), err)
+	}
+	if len(trace.NotFound) == 0 && len(trace.Slow) == 0 {
+		return nil, nil
+	}
+	return &trace, nil
+}
+
+// attachTraces adds the server side trace to every failed result and to its repeated attempts, and
+// restores the scenario urls as they are in scenarios.json. The server may be an older serve without
+// tracing, so the first error disables the rest of the fetches. Mapped hosts are resolved like in the
+// browser, the scenario origin may be a public name like ag-grid.com.
+func attachTraces(results []scenarioResult, originalUrls map[string]string, hosts hostMappings) {
+	client := hosts.httpClient(5 * time.Second)
+	tracing := true
+	fetch := func(r scenarioResult, dir string) *scenarioTrace {
+		if !tracing {
+			return nil
+		}
+		trace, err := fetchScenarioTrace(client, r.Scenario.Url, attemptKey(r.Scenario, dir))
+		if err != nil {
+			fmt.Println("Could not fetch scenario traces, skipping:", err)
+			tracing = false
+		}
+		return trace
+	}
+	for i := range results {
+		if results[i].Status != statusPass {
+			results[i].Trace = fetch(results[i], "")
+			for j := 1; j < len(results[i].Attempts); j++ {
+				results[i].Attempts[j].Trace = fetch(results[i], results[i].Attempts[j].Dir)
+			}
+		}
+		if original, ok := originalUrls[scenarioKey(results[i].Scenario)]; ok {
+			results[i].Scenario.Url = original
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// cachedHeaders are the upstream response headers that are stored with a cache entry and replayed on hit.
+var cachedHeaders = []string{
+	fasthttp.HeaderContentType,
+	fasthttp.HeaderContentEncoding,
+	fasthttp.HeaderETag,
+	fasthttp.HeaderLastModified,
+	fasthttp.HeaderCacheControl,
+	fasthttp.HeaderVary,
+}
+
+type cacheEntry struct {
+	URI        string            `json:"uri"`
+	StatusCode int               `json:"statusCode"`
+	Header     map[string]string `json:"header"`
+	StoredAt   time.Time         `json:"storedAt"`
+	// BodySHA256 ties the metadata to its body file, a body replaced by a concurrent store is a miss
+	BodySHA256 string `json:"bodySha256"`
+}
+
+type cacheStats struct {
+	Dir           string `json:"dir"`
+	TTL           string `json:"ttl"`
+	Offline       bool   `json:"offline"`
+	Entries       int    `json:"entries"`
+	Bytes         int64  `json:"bytes"`
+	Hits          int64  `json:"hits"`
+	Misses        int64  `json:"misses"`
+	Revalidated   int64  `json:"revalidated"`
+	StaleServed   int64  `json:"staleServed"`
+	OfflineMisses int64  `json:"offlineMisses"`
+}
+
+// diskCache stores proxied GET responses on disk, one metadata file and one body file per entry.
+type diskCache struct {
+	dir     string
+	ttl     time.Duration
+	offline bool
+
+	mu sync.Mutex
+
+	hits          atomic.Int64
+	misses        atomic.Int64
+	revalidated   atomic.Int64
+	staleServed   atomic.Int64
+	offlineMisses atomic.Int64
+}
+
+func newDiskCache(dir string, ttl time.Duration, offline bool) (*diskCache, error) {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(absDir, 0755); err != nil {
+		return nil, err
+	}
+	return &diskCache{dir: absDir, ttl: ttl, offline: offline}, nil
+}
+
+// key identifies an entry by the upstream URI and the encodings the client accepts,
+// so a gzip-encoded body is never replayed to a client that cannot decode it.
+func (c *diskCache) key(uri string, acceptEncoding []byte) string {
+	sum := sha256.Sum256([]byte(uri + "\n" + string(acceptEncoding)))
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *diskCache) paths(key string) (string, string) {
+	return filepath.Join(c.dir, key+".json"), filepath.Join(c.dir, key+".body")
+}
+
+func (c *diskCache) load(key string) (*cacheEntry, []byte, bool) {
+	metaPath, bodyPath := c.paths(key)
+
+	metaBytes, err := os.ReadFile(metaPath)
+	if err != nil {
+		return nil, nil, false
+	}
+	var entry cacheEntry
+	if err := json.Unmarshal(metaBytes, &entry); err != nil {
+		return nil, nil, false
+	}
+	body, err := os.ReadFile(bodyPath)
+	if err != nil {
+		return nil, nil, false
+	}
+	if sum := sha256.Sum256(body); hex.EncodeToString(sum[:]) != entry.BodySHA256 {
+		return nil, nil, false
+	}
+	return &entry, body, true
+}
+
+func (c *diskCache) store(key, uri string, resp *fasthttp.Response) error {
+	entry := cacheEntry{
+		URI:        uri,
+		StatusCode: resp.StatusCode(),
+		Header:     map[string]string{},
+		StoredAt:   time.Now(),
+	}
+	sum := sha256.Sum256(resp.Body())
+	entry.BodySHA256 = hex.EncodeToString(sum[:])
+	for _, h := range cachedHeaders {
+		if v := resp.Header.Peek(h); len(v) > 0 {
+			entry.Header[h] = string(v)
+		}
+	}
+
+	metaBytes, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// the metadata goes last, it is what makes the entry visible
+	metaPath, bodyPath := c.paths(key)
+	if err := writeFileAtomic(bodyPath, resp.Body()); err != nil {
+		return err
+	}
+	return writeFileAtomic(metaPath, metaBytes)
+}
+
+// touch marks an entry as fresh again after a successful revalidation.
+func (c *diskCache) touch(key string, entry *cacheEntry) error {
+	entry.StoredAt = time.Now()
+	metaBytes, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	metaPath, _ := c.paths(key)
+	return writeFileAtomic(metaPath, metaBytes)
+}
+
+// writeFileAtomic replaces path through a temporary file in the same directory, so readers see either
+// the old or the new content, never a partial write.
+func writeFileAtomic(path string, content []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (c *diskCache) fresh(entry *cacheEntry) bool {
+	return time.Since(entry.StoredAt) < c.ttl
+}
+
+func (c *diskCache) serve(ctx *fasthttp.RequestCtx, entry *cacheEntry, body []byte, status string) {
+	ctx.SetStatusCode(entry.StatusCode)
+	for h, v := range entry.Header {
+		ctx.Response.Header.Set(h, v)
+	}
+	ctx.Response.Header.Set("X-Cache", status)
+	ctx.SetBody(body)
+}
+
+func (c *diskCache) stats() cacheStats {
+	s := cacheStats{
+		Dir:           c.dir,
+		TTL:           c.ttl.String(),
+		Offline:       c.offline,
+		Hits:          c.hits.Load(),
+		Misses:        c.misses.Load(),
+		Revalidated:   c.revalidated.Load(),
+		StaleServed:   c.staleServed.Load(),
+		OfflineMisses: c.offlineMisses.Load(),
+	}
+
+	files, err := os.ReadDir(c.dir)
+	if err != nil {
+		return s
+	}
+	for _, f := range files {
+		info, err := f.Info()
+		if err != nil {
+			continue
+		}
+		if strings.HasSuffix(f.Name(), ".json") {
+			s.Entries++
+		}
+		s.Bytes += info.Size()
+	}
+	return s
+}
+
+func (c *diskCache) handleStats(ctx *fasthttp.RequestCtx) {
+	statsJSON, err := json.MarshalIndent(c.stats(), "", "  ")
+	if err != nil {
+		ctx.Error(fmt.Sprintf("cache stats error: %v", err), fasthttp.StatusInternalServerError)
+		return
+	}
+	ctx.SetContentType("application/json")
+	ctx.SetBody(statsJSON)
+}
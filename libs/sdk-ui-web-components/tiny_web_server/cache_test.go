@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+func cacheResponse(body string) *fasthttp.Response {
+	resp := &fasthttp.Response{}
+	resp.SetStatusCode(fasthttp.StatusOK)
+	resp.Header.SetContentType("application/javascript")
+	resp.SetBodyString(body)
+	return resp
+}
+
+func TestDiskCacheStoreLoad(t *testing.T) {
+	c, err := newDiskCache(t.TempDir(), time.Hour, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := c.key("https://upstream/components/a.js", []byte("gzip"))
+
+	if _, _, ok := c.load(key); ok {
+		t.Fatal("empty cache returned an entry")
+	}
+	if err = c.store(key, "https://upstream/components/a.js", cacheResponse("export const a = 1;")); err != nil {
+		t.Fatal(err)
+	}
+	entry, body, ok := c.load(key)
+	if !ok {
+		t.Fatal("stored entry not loaded")
+	}
+	if string(body) != "export const a = 1;" || entry.Header[fasthttp.HeaderContentType] != "application/javascript" {
+		t.Fatalf("loaded %q with headers %v", body, entry.Header)
+	}
+
+	// a body that does not belong to the metadata is a miss, not a wrong response
+	_, bodyPath := c.paths(key)
+	if err = os.WriteFile(bodyPath, []byte("export const a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, ok = c.load(key); ok {
+		t.Fatal("truncated body served")
+	}
+}
+
+func TestDiskCacheConcurrentStoreLoad(t *testing.T) {
+	c, err := newDiskCache(t.TempDir(), time.Hour, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := c.key("https://upstream/components/b.js", nil)
+
+	payload := bytes.Repeat([]byte("x"), 64*1024)
+	var wg sync.WaitGroup
+	for i := range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range 50 {
+				body := fmt.Sprintf("%d-%d:%s", i, j, payload)
+				if err := c.store(key, "https://upstream/components/b.js", cacheResponse(body)); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 200 {
+				// every stored body ends with the whole payload, a partial write does not
+				if _, body, ok := c.load(key); ok && !bytes.HasSuffix(body, payload) {
+					t.Errorf("loaded a partial body of %d bytes", len(body))
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
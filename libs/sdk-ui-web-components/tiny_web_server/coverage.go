@@ -0,0 +1,380 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	coverageIstanbulFile = "istanbul.json"
+	coverageV8File       = "v8.json"
+)
+
+var coverageRunRe = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+// coverageFlushScript is injected into test pages; it posts window.__coverage__ (Istanbul instrumented
+// bundles) when the page goes away. The run is taken from the ?coverageRun= query parameter of the page.
+// Beacons are limited to ~64 KiB by browsers, so tests with bigger bundles should await
+// window.__flushCoverage__() explicitly before leaving the page.
+const coverageFlushScript = `(function () {
+    var run = new URLSearchParams(window.location.search).get("coverageRun") || "default";
+    var url = "/__coverage?run=" + encodeURIComponent(run);
+    window.__flushCoverage__ = function () {
+        if (!window.__coverage__) {
+            return Promise.resolve();
+        }
+        return fetch(url, { method: "POST", body: JSON.stringify(window.__coverage__) });
+    };
+    window.addEventListener("pagehide", function () {
+        if (!window.__coverage__) {
+            return;
+        }
+        var payload = JSON.stringify(window.__coverage__);
+        if (!navigator.sendBeacon(url, payload)) {
+            fetch(url, { method: "POST", body: payload, keepalive: payload.length < 65536 });
+        }
+    });
+})();
+`
+
+// coverageStore merges Istanbul and V8 coverage payloads per run into <dir>/<run>/{istanbul,v8}.json.
+type coverageStore struct {
+	dir    string
+	inject bool
+
+	mu sync.Mutex
+}
+
+func newCoverageStore(dir string, inject bool) (*coverageStore, error) {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(absDir, 0755); err != nil {
+		return nil, err
+	}
+	return &coverageStore{dir: absDir, inject: inject}, nil
+}
+
+type v8Range struct {
+	StartOffset int   `json:"startOffset"`
+	EndOffset   int   `json:"endOffset"`
+	Count       int64 `json:"count"`
+}
+
+type v8Function struct {
+	FunctionName    string    `json:"functionName"`
+	Ranges          []v8Range `json:"ranges"`
+	IsBlockCoverage bool      `json:"isBlockCoverage"`
+}
+
+type v8Script struct {
+	ScriptId  string       `json:"scriptId,omitempty"`
+	URL       string       `json:"url"`
+	Functions []v8Function `json:"functions"`
+}
+
+type v8Coverage struct {
+	Result []v8Script `json:"result"`
+}
+
+// istanbulFile keeps the maps of a file coverage as they are and only decodes the hit counters.
+type istanbulFile map[string]json.RawMessage
+
+// parseCoveragePayload detects the payload format: a V8 profile ({"result": [...]} or a bare array of
+// scripts) or an Istanbul coverage map keyed by file path.
+func parseCoveragePayload(body []byte) (*v8Coverage, map[string]istanbulFile, error) {
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 {
+		return nil, nil, errors.New("empty coverage payload")
+	}
+
+	if body[0] == '[' {
+		var scripts []v8Script
+		if err := json.Unmarshal(body, &scripts); err != nil {
+			return nil, nil, fmt.Errorf("invalid V8 coverage: %w", err)
+		}
+		return &v8Coverage{Result: scripts}, nil, nil
+	}
+
+	var probe map[string]json.RawMessage
+	if err := json.Unmarshal(body, &probe); err != nil {
+		return nil, nil, fmt.Errorf("invalid coverage payload: %w", err)
+	}
+	if _, ok := probe["result"]; ok {
+		var v8 v8Coverage
+		if err := json.Unmarshal(body, &v8); err != nil {
+			return nil, nil, fmt.Errorf("invalid V8 coverage: %w", err)
+		}
+		return &v8, nil, nil
+	}
+
+	var istanbul map[string]istanbulFile
+	if err := json.Unmarshal(body, &istanbul); err != nil {
+		return nil, nil, fmt.Errorf("invalid Istanbul coverage: %w", err)
+	}
+	for file, fc := range istanbul {
+		if _, ok := fc["statementMap"]; !ok {
+			return nil, nil, fmt.Errorf("invalid Istanbul coverage: %q has no statementMap", file)
+		}
+	}
+	return nil, istanbul, nil
+}
+
+func sumCounters[T map[string]int64 | map[string][]int64](dst, src json.RawMessage, add func(a, b T)) (json.RawMessage, error) {
+	var a, b T
+	if err := json.Unmarshal(dst, &a); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(src, &b); err != nil {
+		return nil, err
+	}
+	add(a, b)
+	return json.Marshal(a)
+}
+
+func mergeIstanbul(dst, src map[string]istanbulFile) error {
+	for file, fc := range src {
+		existing, ok := dst[file]
+		if !ok {
+			dst[file] = fc
+			continue
+		}
+
+		var err error
+		addCounts := func(a, b map[string]int64) {
+			for id, n := range b {
+				a[id] += n
+			}
+		}
+		for _, key := range []string{"s", "f"} {
+			if existing[key], err = sumCounters(existing[key], fc[key], addCounts); err != nil {
+				return fmt.Errorf("merge %s.%s: %w", file, key, err)
+			}
+		}
+		existing["b"], err = sumCounters(existing["b"], fc["b"], func(a, b map[string][]int64) {
+			for id, counts := range b {
+				if len(a[id]) != len(counts) {
+					a[id] = counts
+					continue
+				}
+				for i, n := range counts {
+					a[id][i] += n
+				}
+			}
+		})
+		if err != nil {
+			return fmt.Errorf("merge %s.b: %w", file, err)
+		}
+	}
+	return nil
+}
+
+func v8FunctionKey(fn v8Function) string {
+	if len(fn.Ranges) == 0 {
+		return fn.FunctionName
+	}
+	return fmt.Sprintf("%s:%d:%d", fn.FunctionName, fn.Ranges[0].StartOffset, fn.Ranges[0].EndOffset)
+}
+
+func mergeV8(dst, src *v8Coverage) {
+	scripts := map[string]int{}
+	for i, s := range dst.Result {
+		scripts[s.URL] = i
+	}
+
+	for _, s := range src.Result {
+		i, ok := scripts[s.URL]
+		if !ok {
+			scripts[s.URL] = len(dst.Result)
+			dst.Result = append(dst.Result, s)
+			continue
+		}
+
+		existing := &dst.Result[i]
+		functions := map[string]int{}
+		for j, fn := range existing.Functions {
+			functions[v8FunctionKey(fn)] = j
+		}
+		for _, fn := range s.Functions {
+			j, ok := functions[v8FunctionKey(fn)]
+			if !ok {
+				existing.Functions = append(existing.Functions, fn)
+				continue
+			}
+			ranges := existing.Functions[j].Ranges
+			if len(ranges) != len(fn.Ranges) {
+				// block coverage differs in shape, keep the more detailed one
+				if len(fn.Ranges) > len(ranges) {
+					existing.Functions[j] = fn
+				}
+				continue
+			}
+			for k, r := range fn.Ranges {
+				ranges[k].Count += r.Count
+			}
+		}
+	}
+}
+
+// readJSONFile leaves v untouched when the file does not exist yet.
+func readJSONFile(path string, v any) error {
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(content, v)
+}
+
+func writeJSONFile(path string, v any) error {
+	content, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, content)
+}
+
+func (c *coverageStore) add(run string, body []byte) error {
+	v8, istanbul, err := parseCoveragePayload(body)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	runDir := filepath.Join(c.dir, run)
+	if err := os.MkdirAll(runDir, 0755); err != nil {
+		return err
+	}
+
+	if v8 != nil {
+		path := filepath.Join(runDir, coverageV8File)
+		merged := &v8Coverage{}
+		if err := readJSONFile(path, merged); err != nil {
+			return fmt.Errorf("read %s: %w", path, err)
+		}
+		mergeV8(merged, v8)
+		return writeJSONFile(path, merged)
+	}
+
+	path := filepath.Join(runDir, coverageIstanbulFile)
+	merged := map[string]istanbulFile{}
+	if err := readJSONFile(path, &merged); err != nil {
+		return fmt.Errorf("read %s: %w", path, err)
+	}
+	if err := mergeIstanbul(merged, istanbul); err != nil {
+		return err
+	}
+	return writeJSONFile(path, merged)
+}
+
+func (c *coverageStore) runs() []string {
+	runs := []string{}
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return runs
+	}
+	for _, e := range entries {
+		if e.IsDir() {
+			runs = append(runs, e.Name())
+		}
+	}
+	return runs
+}
+
+// handle serves the coverage endpoints:
+//
+//	POST /__coverage?run=<id>                merge an Istanbul or V8 payload into the run
+//	GET  /__coverage                         list runs
+//	GET  /__coverage/<id>/istanbul.json      download merged Istanbul coverage
+//	GET  /__coverage/<id>/v8.json            download merged V8 coverage
+//	GET  /__coverage/flush.js                the injected flush script
+func (c *coverageStore) handle(ctx *fasthttp.RequestCtx) {
+	p := string(ctx.Path())
+
+	switch {
+	case p == "/__coverage" && ctx.IsPost():
+		run := string(ctx.QueryArgs().Peek("run"))
+		if run == "" {
+			run = "default"
+		}
+		if !coverageRunRe.MatchString(run) {
+			ctx.Error(fmt.Sprintf("invalid coverage run %q", run), fasthttp.StatusBadRequest)
+			return
+		}
+		if err := c.add(run, ctx.PostBody()); err != nil {
+			ctx.Error(err.Error(), fasthttp.StatusBadRequest)
+			return
+		}
+		ctx.SetStatusCode(fasthttp.StatusNoContent)
+	case p == "/__coverage":
+		runsJSON, _ := json.Marshal(c.runs())
+		ctx.SetContentType("application/json")
+		ctx.SetBody(runsJSON)
+	case p == "/__coverage/flush.js":
+		ctx.SetContentType("text/javascript; charset=utf-8")
+		ctx.SetBodyString(coverageFlushScript)
+	default:
+		parts := strings.Split(strings.TrimPrefix(p, "/__coverage/"), "/")
+		if len(parts) != 2 || !coverageRunRe.MatchString(parts[0]) ||
+			(parts[1] != coverageIstanbulFile && parts[1] != coverageV8File) {
+			ctx.Error("not found", fasthttp.StatusNotFound)
+			return
+		}
+
+		c.mu.Lock()
+		content, err := os.ReadFile(filepath.Join(c.dir, parts[0], parts[1]))
+		c.mu.Unlock()
+		if err != nil {
+			ctx.Error(fmt.Sprintf("no %s coverage for run %q", strings.TrimSuffix(parts[1], ".json"), parts[0]), fasthttp.StatusNotFound)
+			return
+		}
+		ctx.SetContentType("application/json")
+		ctx.Response.Header.Set(fasthttp.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="coverage-%s-%s"`, parts[0], parts[1]))
+		ctx.SetBody(content)
+	}
+}
+
+// prepareInjection makes sure the test page comes back uncompressed and complete, so the flush script
+// can be spliced in.
+func (c *coverageStore) prepareInjection(ctx *fasthttp.RequestCtx) bool {
+	if !c.inject || !strings.HasSuffix(string(ctx.Path()), ".html") {
+		return false
+	}
+	requestIdentity(ctx)
+	return true
+}
+
+func (c *coverageStore) injectFlushScript(ctx *fasthttp.RequestCtx) {
+	if ctx.Response.StatusCode() != fasthttp.StatusOK {
+		return
+	}
+	tag := []byte(`<script src="/__coverage/flush.js"></script>`)
+	body := ctx.Response.Body()
+	idx := bytes.Index(body, []byte("</head>"))
+	if idx < 0 {
+		return
+	}
+
+	injected := make([]byte, 0, len(body)+len(tag))
+	injected = append(injected, body[:idx]...)
+	injected = append(injected, tag...)
+	injected = append(injected, body[idx:]...)
+	ctx.SetBody(injected)
+	// the page no longer matches the file on disk
+	ctx.Response.Header.Del(fasthttp.HeaderETag)
+	ctx.Response.Header.Del(fasthttp.HeaderLastModified)
+}
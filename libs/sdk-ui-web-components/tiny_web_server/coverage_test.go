@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func istanbulPayload(t *testing.T, payload string) map[string]istanbulFile {
+	t.Helper()
+	v8, istanbul, err := parseCoveragePayload([]byte(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v8 != nil {
+		t.Fatal("Istanbul payload detected as V8")
+	}
+	return istanbul
+}
+
+func counters[T any](t *testing.T, raw json.RawMessage) T {
+	t.Helper()
+	var v T
+	if err := json.Unmarshal(raw, &v); err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestMergeIstanbul(t *testing.T) {
+	dst := istanbulPayload(t, `{
+		"a.js": {"statementMap": {}, "s": {"0": 1, "1": 0}, "f": {"0": 2}, "b": {"0": [1, 0], "1": [1]}}
+	}`)
+	src := istanbulPayload(t, `{
+		"a.js": {"statementMap": {}, "s": {"0": 2, "1": 3}, "f": {"0": 1}, "b": {"0": [0, 4], "1": [2, 2]}},
+		"b.js": {"statementMap": {}, "s": {"0": 7}, "f": {}, "b": {}}
+	}`)
+
+	if err := mergeIstanbul(dst, src); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := counters[map[string]int64](t, dst["a.js"]["s"]), map[string]int64{"0": 3, "1": 3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("s = %v, want %v", got, want)
+	}
+	if got, want := counters[map[string]int64](t, dst["a.js"]["f"]), map[string]int64{"0": 3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("f = %v, want %v", got, want)
+	}
+	// branches of a different shape come from another build, the newer counts replace them
+	if got, want := counters[map[string][]int64](t, dst["a.js"]["b"]), map[string][]int64{"0": {1, 4}, "1": {2, 2}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("b = %v, want %v", got, want)
+	}
+	if _, ok := dst["b.js"]; !ok {
+		t.Error("new file not added")
+	}
+}
+
+func TestMergeV8(t *testing.T) {
+	dst := &v8Coverage{Result: []v8Script{{
+		URL: "https://localhost/a.js",
+		Functions: []v8Function{
+			{FunctionName: "f", Ranges: []v8Range{{StartOffset: 0, EndOffset: 10, Count: 1}, {StartOffset: 2, EndOffset: 4, Count: 0}}},
+			{FunctionName: "g", Ranges: []v8Range{{StartOffset: 20, EndOffset: 30, Count: 1}}},
+		},
+	}}}
+	src := &v8Coverage{Result: []v8Script{
+		{
+			URL: "https://localhost/a.js",
+			Functions: []v8Function{
+				{FunctionName: "f", Ranges: []v8Range{{StartOffset: 0, EndOffset: 10, Count: 2}, {StartOffset: 2, EndOffset: 4, Count: 5}}},
+				{FunctionName: "g", Ranges: []v8Range{{StartOffset: 20, EndOffset: 30, Count: 1}, {StartOffset: 22, EndOffset: 24, Count: 1}}},
+				{FunctionName: "h", Ranges: []v8Range{{StartOffset: 40, EndOffset: 50, Count: 1}}},
+			},
+		},
+		{URL: "https://localhost/b.js"},
+	}}
+
+	mergeV8(dst, src)
+
+	if len(dst.Result) != 2 || dst.Result[1].URL != "https://localhost/b.js" {
+		t.Fatalf("scripts = %+v", dst.Result)
+	}
+	functions := dst.Result[0].Functions
+	if len(functions) != 3 {
+		t.Fatalf("functions = %+v", functions)
+	}
+	if got := []int64{functions[0].Ranges[0].Count, functions[0].Ranges[1].Count}; !reflect.DeepEqual(got, []int64{3, 5}) {
+		t.Errorf("f counts = %v, want [3 5]", got)
+	}
+	// the block coverage of g is more detailed in src
+	if len(functions[1].Ranges) != 2 {
+		t.Errorf("g ranges = %+v, want the block coverage", functions[1].Ranges)
+	}
+	if functions[2].FunctionName != "h" {
+		t.Errorf("new function = %+v", functions[2])
+	}
+}
+
+func TestParseCoveragePayload(t *testing.T) {
+	tests := []struct {
+		name     string
+		payload  string
+		wantV8   bool
+		wantFail bool
+	}{
+		{name: "v8 profile", payload: `{"result": [{"url": "a.js", "functions": []}]}`, wantV8: true},
+		{name: "v8 scripts", payload: `[{"url": "a.js", "functions": []}]`, wantV8: true},
+		{name: "istanbul", payload: `{"a.js": {"statementMap": {}, "s": {}}}`},
+		{name: "empty", payload: " ", wantFail: true},
+		{name: "not coverage", payload: `{"a.js": {"s": {}}}`, wantFail: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v8, istanbul, err := parseCoveragePayload([]byte(tt.payload))
+			if (err != nil) != tt.wantFail {
+				t.Fatalf("error %v, want failure %t", err, tt.wantFail)
+			}
+			if err == nil && (v8 != nil) != tt.wantV8 {
+				t.Fatalf("v8 %v istanbul %v, want V8 %t", v8, istanbul, tt.wantV8)
+			}
+		})
+	}
+}
@@ -2,10 +2,16 @@ module tiny_web_server
 
 go 1.26.2
 
-require github.com/valyala/fasthttp v1.68.0
+require (
+	github.com/playwright-community/playwright-go v0.5200.1
+	github.com/valyala/fasthttp v1.68.0
+)
 
 require (
 	github.com/andybalholm/brotli v1.2.0 // indirect
+	github.com/deckarep/golang-set/v2 v2.7.0 // indirect
+	github.com/go-jose/go-jose/v3 v3.0.4 // indirect
+	github.com/go-stack/stack v1.8.1 // indirect
 	github.com/klauspost/compress v1.18.2 // indirect
 	github.com/valyala/bytebufferpool v1.0.0 // indirect
 )
@@ -4,17 +4,18 @@ import (
 	"bufio"
 	"crypto/rand"
 	"crypto/rsa"
-	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/json"
 	"encoding/pem"
+	"flag"
 	"fmt"
 	"log"
 	"math/big"
 	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"time"
 
@@ -28,7 +29,8 @@ type envConfig struct {
 	Auth        string `json:"auth"`
 }
 
-func loadEnvConfig() envConfig {
+// readDotEnv parses KEY=VALUE lines of the .env file in the working directory, if there is one.
+func readDotEnv() map[string]string {
 	config := map[string]string{}
 
 	if file, err := os.Open(".env"); err == nil {
@@ -50,6 +52,12 @@ func loadEnvConfig() envConfig {
 		}
 	}
 
+	return config
+}
+
+func loadEnvConfig() envConfig {
+	config := readDotEnv()
+
 	pick := func(envFileKey, defaultValue string) string {
 		if v, ok := config[envFileKey]; ok && v != "" {
 			return v
@@ -68,7 +76,12 @@ func loadEnvConfig() envConfig {
 	}
 }
 
-func generateConfigFile(staticDir string) {
+// configScript renders the config.js that exposes cfgJSON to the test page as window.__WC_TEST_CONFIG__.
+func configScript(header string, cfgJSON []byte) []byte {
+	return []byte(fmt.Sprintf("%swindow.__WC_TEST_CONFIG__ = %s;\n", header, string(cfgJSON)))
+}
+
+func generateConfigFile(staticDir string) envConfig {
 	cfg := loadEnvConfig()
 	configDir := filepath.Join(staticDir, "web-components")
 	configFile := filepath.Join(configDir, "config.js")
@@ -82,13 +95,15 @@ func generateConfigFile(staticDir string) {
 		log.Fatalf("Failed to marshal config: %v", err)
 	}
 
-	content := fmt.Sprintf("// Auto-generated config from .env file\n// This file is regenerated when the server starts\nwindow.__WC_TEST_CONFIG__ = %s;\n", string(cfgJSON))
+	content := configScript("// Auto-generated config from .env file\n// This file is regenerated when the server starts\n", cfgJSON)
 
-	if err := os.WriteFile(configFile, []byte(content), 0644); err != nil {
+	if err := os.WriteFile(configFile, content, 0644); err != nil {
 		log.Fatalf("Failed to write config file: %v", err)
 	}
 
 	fmt.Printf("Generated config.js from .env: %s\n", string(cfgJSON))
+
+	return cfg
 }
 
 func generateSelfSignedCert() ([]byte, []byte, error) {
@@ -127,73 +142,234 @@ func setCORSHeaders(ctx *fasthttp.RequestCtx) {
 	ctx.Response.Header.Set("Access-Control-Allow-Headers", "*")
 }
 
-func main() {
-	port := 3001
+// serverOptions are the command line options of the server, shared by the selfcheck command.
+type serverOptions struct {
+	port           int
+	cacheDir       string
+	cacheTTL       time.Duration
+	offline        bool
+	modulePreload  bool
+	preloadEntries string
+	earlyHints     bool
+	importMap      bool
+	coverageDir    string
+	coverageInject bool
+	rewrites       rewriteRules
+	rewriteHost    bool
+	rewriteCookies bool
+}
+
+func registerServerFlags(fs *flag.FlagSet) *serverOptions {
+	opts := &serverOptions{}
+	fs.IntVar(&opts.port, "port", 3001, "HTTPS port to listen on")
+	fs.StringVar(&opts.cacheDir, "cache-dir", "", "cache proxied /components/* responses in this directory (disabled when empty)")
+	fs.DurationVar(&opts.cacheTTL, "cache-ttl", 10*time.Minute, "how long a cached response is served before it is revalidated upstream")
+	fs.BoolVar(&opts.offline, "offline", false, "serve proxied /components/* only from --cache-dir, never contact PROXY_HOST")
+	fs.BoolVar(&opts.modulePreload, "modulepreload", false, "analyze the static module graph of --preload-entries and send Link: rel=modulepreload headers")
+	fs.StringVar(&opts.preloadEntries, "preload-entries", "/components/index.js,/components/tigerBackend.js", "comma-separated entry modules for --modulepreload")
+	fs.BoolVar(&opts.earlyHints, "early-hints", false, "send the modulepreload links as 103 Early Hints before test pages (requires --modulepreload)")
+	fs.BoolVar(&opts.importMap, "import-map", false, "serve an import map for the preload entries at /__importmap.json (requires --modulepreload)")
+	fs.StringVar(&opts.coverageDir, "coverage-dir", "", "accept POST /__coverage payloads and merge them per run in this directory (disabled when empty)")
+	fs.BoolVar(&opts.coverageInject, "coverage-inject", false, "inject a script flushing window.__coverage__ on pagehide into .html pages (requires --coverage-dir)")
+	fs.Var(&opts.rewrites, "rewrite", "replace FROM with TO in proxied text bodies and Location headers, FROM=TO (repeatable, empty TO means the dev origin)")
+	fs.BoolVar(&opts.rewriteHost, "rewrite-upstream", false, "rewrite absolute PROXY_HOST URLs in proxied responses to the dev origin")
+	fs.BoolVar(&opts.rewriteCookies, "rewrite-cookies", false, "drop Domain from proxied Set-Cookie headers and mark them Secure, so they stick to the dev host")
+	return opts
+}
+
+// newServer generates config.js and the self-signed certificate and wires up all handlers.
+// It returns the server with the certificate and key PEM to serve TLS with.
+func newServer(opts *serverOptions) (*fasthttp.Server, []byte, []byte) {
+	port := opts.port
 
 	absFolder, err := filepath.Abs("./static/")
 	if err != nil {
 		log.Fatalf("Failed to get absolute path: %v", err)
 	}
 
-	generateConfigFile(absFolder)
+	cfg := generateConfigFile(absFolder)
+
+	presets, err := loadPresets()
+	if err != nil {
+		log.Fatalf("Invalid presets: %v", err)
+	}
+	routes := &virtualRoutes{base: cfg, presets: presets}
+	if len(presets) > 0 {
+		fmt.Printf("Presets for /p/{name}/...: %s\n", strings.Join(routes.presetNames(), ", "))
+	}
 
 	certPEM, keyPEM, err := generateSelfSignedCert()
 	if err != nil {
 		log.Fatalf("Failed to generate self-signed cert: %v", err)
 	}
 
+	var cache *diskCache
+	if opts.cacheDir != "" {
+		cache, err = newDiskCache(opts.cacheDir, opts.cacheTTL, opts.offline)
+		if err != nil {
+			log.Fatalf("Failed to create cache dir: %v", err)
+		}
+	} else if opts.offline {
+		log.Fatalf("--offline requires --cache-dir")
+	}
+
 	proxyHost := strings.TrimRight(os.Getenv("PROXY_HOST"), "/")
-	var proxyClient *fasthttp.Client
-	var proxyHostHeader string
+	var upstream *proxy
 	if proxyHost != "" {
 		u, err := url.Parse(proxyHost)
 		if err != nil || u.Host == "" {
 			log.Fatalf("Invalid PROXY_HOST %q: %v", proxyHost, err)
 		}
-		proxyHostHeader = u.Host
-		proxyClient = &fasthttp.Client{
-			TLSConfig: &tls.Config{InsecureSkipVerify: true},
+		upstream = &proxy{
+			host:       proxyHost,
+			hostHeader: u.Host,
+			cache:      cache,
+		}
+		if opts.rewriteHost || len(opts.rewrites) > 0 || opts.rewriteCookies {
+			rules := slices.Clone(opts.rewrites)
+			if opts.rewriteHost {
+				rules = append(rules, rewriteRule{From: proxyHost})
+			}
+			upstream.rewriter = &rewriter{rules: rules, cookies: opts.rewriteCookies}
+		}
+		if !opts.offline {
+			tlsOpts, err := loadProxyTLSOptions()
+			if err != nil {
+				log.Fatalf("Invalid proxy TLS configuration: %v", err)
+			}
+			upstream.client = newProxyClient(tlsOpts)
 		}
 		fmt.Printf("Proxying /components/* -> %s\n", proxyHost)
+		if cache != nil {
+			fmt.Printf("Caching proxied responses in %s (ttl %s, offline %t)\n", cache.dir, cache.ttl, cache.offline)
+		}
 	} else {
+		if opts.rewriteHost || len(opts.rewrites) > 0 || opts.rewriteCookies {
+			log.Fatalf("--rewrite, --rewrite-upstream and --rewrite-cookies require PROXY_HOST")
+		}
+		if opts.offline {
+			log.Fatalf("--offline requires PROXY_HOST, cache entries are only used in proxy mode")
+		}
 		fmt.Printf("Serving /components/* from local static dir\n")
 	}
 
 	fmt.Printf("Serving sdk-ui-web-components from: %s on port %d (HTTPS, self-signed)\n", absFolder, port)
 
-	fs := &fasthttp.FS{
-		Root:               absFolder,
-		IndexNames:         []string{"index.html"},
-		GenerateIndexPages: false,
-		AcceptByteRange:    true,
+	static := newStaticHandler(absFolder)
+
+	var coverage *coverageStore
+	if opts.coverageDir != "" {
+		coverage, err = newCoverageStore(opts.coverageDir, opts.coverageInject)
+		if err != nil {
+			log.Fatalf("Failed to create coverage dir: %v", err)
+		}
+		fmt.Printf("Collecting coverage in %s (inject flush script %t)\n", coverage.dir, coverage.inject)
+	} else if opts.coverageInject {
+		log.Fatalf("--coverage-inject requires --coverage-dir")
 	}
-	fsHandler := fs.NewRequestHandler()
 
-	requestHandler := func(ctx *fasthttp.RequestCtx) {
+	var graph *moduleGraph
+	if opts.modulePreload {
+		if upstream != nil {
+			log.Fatalf("--modulepreload analyzes the local bundle and cannot be used with PROXY_HOST")
+		}
+		graph, err = buildModuleGraph(absFolder, strings.Split(opts.preloadEntries, ","))
+		if err != nil {
+			log.Fatalf("Failed to analyze module graph: %v", err)
+		}
+		graph.htmlPrefix = "/web-components/"
+		graph.earlyHints = opts.earlyHints
+		if opts.importMap {
+			if err := graph.generateImportMap("@components/"); err != nil {
+				log.Fatalf("Failed to generate import map: %v", err)
+			}
+		}
+		fmt.Printf("Module graph: %d modules, %d bytes, max import depth %d (see /__modulegraph)\n", len(graph.Modules), graph.TotalBytes, graph.MaxDepth)
+	} else if opts.earlyHints || opts.importMap {
+		log.Fatalf("--early-hints and --import-map require --modulepreload")
+	}
+
+	serve := func(ctx *fasthttp.RequestCtx) {
 		setCORSHeaders(ctx)
 
-		if proxyClient != nil && strings.HasPrefix(string(ctx.Path()), "/components") {
-			req := fasthttp.AcquireRequest()
-			resp := fasthttp.AcquireResponse()
-			defer fasthttp.ReleaseRequest(req)
-			defer fasthttp.ReleaseResponse(resp)
+		if cache != nil && string(ctx.Path()) == "/__cache" {
+			cache.handleStats(ctx)
+			return
+		}
 
-			ctx.Request.CopyTo(req)
-			req.SetRequestURI(proxyHost + string(ctx.RequestURI()))
-			req.Header.SetHost(proxyHostHeader)
+		if upstream != nil && strings.HasPrefix(string(ctx.Path()), "/components") {
+			upstream.handle(ctx)
+			setCORSHeaders(ctx)
+			return
+		}
 
-			if err := proxyClient.Do(req, resp); err != nil {
-				ctx.SetStatusCode(fasthttp.StatusBadGateway)
-				ctx.SetBodyString(fmt.Sprintf("proxy error: %v", err))
-				return
-			}
-			resp.CopyTo(&ctx.Response)
+		if coverage != nil && strings.HasPrefix(string(ctx.Path()), "/__coverage") {
+			coverage.handle(ctx)
+			return
+		}
+
+		if graph != nil && graph.applyHints(ctx) {
+			return
+		}
+
+		if coverage != nil && coverage.prepareInjection(ctx) {
+			static.handle(ctx)
+			coverage.injectFlushScript(ctx)
+			return
+		}
+
+		static.handle(ctx)
+	}
+
+	requestHandler := func(ctx *fasthttp.RequestCtx) {
+		if string(ctx.Path()) == "/__presets" {
+			setCORSHeaders(ctx)
+			routes.handlePresets(ctx)
+			return
+		}
+
+		m, err := routes.match(string(ctx.Path()))
+		if err != nil {
 			setCORSHeaders(ctx)
+			ctx.Error(err.Error(), fasthttp.StatusNotFound)
+			return
+		}
+		if m != nil {
+			routes.serve(ctx, m, serve)
 			return
 		}
 
-		fsHandler(ctx)
+		serve(ctx)
+	}
+
+	stats := newMetrics()
+	if upstream != nil {
+		stats.upstreamErrors = upstream.errors.Load
+	}
+	proxied := func(path string) bool {
+		return upstream != nil && strings.HasPrefix(path, "/components")
+	}
+
+	server := &fasthttp.Server{
+		Handler: stats.instrument(requestHandler, proxied),
+		// coverage payloads of the whole bundle are far beyond the 4 MiB default
+		MaxRequestBodySize: 512 * 1024 * 1024,
 	}
 
-	log.Fatal(fasthttp.ListenAndServeTLSEmbed(fmt.Sprintf(":%d", port), certPEM, keyPEM, requestHandler))
+	stats.openConnections = server.GetOpenConnectionsCount
+
+	return server, certPEM, keyPEM
+}
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "selfcheck" {
+		os.Exit(runSelfcheck(os.Args[2:]))
+	}
+
+	opts := registerServerFlags(flag.CommandLine)
+	flag.Parse()
+
+	server, certPEM, keyPEM := newServer(opts)
+
+	log.Fatal(server.ListenAndServeTLSEmbed(fmt.Sprintf(":%d", opts.port), certPEM, keyPEM))
 }
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// Route sources the request metrics are broken down by.
+const (
+	sourceStatic   = "static"
+	sourceProxy    = "proxy"
+	sourceConfig   = "config"
+	sourceInternal = "internal"
+)
+
+// latencyBuckets are the upper bounds of the request duration histogram, in seconds.
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type requestKey struct {
+	source string
+	code   int
+}
+
+type histogram struct {
+	counts []uint64 // per bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+// metrics collects request counters in-process and renders them in the Prometheus text format.
+type metrics struct {
+	mu        sync.Mutex
+	requests  map[requestKey]uint64
+	bytes     map[string]uint64
+	latencies map[string]*histogram
+
+	// upstreamErrors and openConnections are read at scrape time
+	upstreamErrors  func() int64
+	openConnections func() int32
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		requests:  map[requestKey]uint64{},
+		bytes:     map[string]uint64{},
+		latencies: map[string]*histogram{},
+	}
+}
+
+func (m *metrics) observe(source string, code int, size int, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.requests[requestKey{source, code}]++
+	if size > 0 {
+		m.bytes[source] += uint64(size)
+	}
+
+	h, ok := m.latencies[source]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(latencyBuckets))}
+		m.latencies[source] = h
+	}
+	seconds := d.Seconds()
+	if i, _ := slices.BinarySearch(latencyBuckets, seconds); i < len(latencyBuckets) {
+		h.counts[i]++
+	}
+	h.sum += seconds
+	h.count++
+}
+
+// instrument wraps next and classifies each request by the path it was finally served under.
+func (m *metrics) instrument(next fasthttp.RequestHandler, proxied func(path string) bool) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		if string(ctx.Path()) == "/metrics" {
+			m.handle(ctx)
+			return
+		}
+
+		t0 := time.Now()
+		next(ctx)
+
+		p := string(ctx.Path())
+		source := sourceStatic
+		switch {
+		case strings.HasSuffix(p, configScriptPath):
+			source = sourceConfig
+		case strings.HasPrefix(p, "/__"):
+			source = sourceInternal
+		case proxied(p):
+			source = sourceProxy
+		}
+		m.observe(source, ctx.Response.StatusCode(), ctx.Response.Header.ContentLength(), time.Since(t0))
+	}
+}
+
+func (m *metrics) handle(ctx *fasthttp.RequestCtx) {
+	var b strings.Builder
+
+	m.mu.Lock()
+
+	b.WriteString("# HELP tiny_web_server_requests_total Requests served, by route source and status code.\n")
+	b.WriteString("# TYPE tiny_web_server_requests_total counter\n")
+	keys := make([]requestKey, 0, len(m.requests))
+	for k := range m.requests {
+		keys = append(keys, k)
+	}
+	slices.SortFunc(keys, func(a, b requestKey) int {
+		if c := strings.Compare(a.source, b.source); c != 0 {
+			return c
+		}
+		return a.code - b.code
+	})
+	for _, k := range keys {
+		fmt.Fprintf(&b, "tiny_web_server_requests_total{source=%q,code=\"%d\"} %d\n", k.source, k.code, m.requests[k])
+	}
+
+	b.WriteString("# HELP tiny_web_server_request_duration_seconds Request latency, by route source.\n")
+	b.WriteString("# TYPE tiny_web_server_request_duration_seconds histogram\n")
+	for _, source := range slices.Sorted(maps.Keys(m.latencies)) {
+		h := m.latencies[source]
+		var cumulative uint64
+		for i, le := range latencyBuckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(&b, "tiny_web_server_request_duration_seconds_bucket{source=%q,le=%q} %d\n", source, strconv.FormatFloat(le, 'f', -1, 64), cumulative)
+		}
+		fmt.Fprintf(&b, "tiny_web_server_request_duration_seconds_bucket{source=%q,le=\"+Inf\"} %d\n", source, h.count)
+		fmt.Fprintf(&b, "tiny_web_server_request_duration_seconds_sum{source=%q} %s\n", source, strconv.FormatFloat(h.sum, 'f', -1, 64))
+		fmt.Fprintf(&b, "tiny_web_server_request_duration_seconds_count{source=%q} %d\n", source, h.count)
+	}
+
+	b.WriteString("# HELP tiny_web_server_response_bytes_total Response body bytes served, by route source.\n")
+	b.WriteString("# TYPE tiny_web_server_response_bytes_total counter\n")
+	for _, source := range slices.Sorted(maps.Keys(m.bytes)) {
+		fmt.Fprintf(&b, "tiny_web_server_response_bytes_total{source=%q} %d\n", source, m.bytes[source])
+	}
+
+	m.mu.Unlock()
+
+	b.WriteString("# HELP tiny_web_server_upstream_errors_total Proxied requests that failed to reach PROXY_HOST.\n")
+	b.WriteString("# TYPE tiny_web_server_upstream_errors_total counter\n")
+	var upstreamErrors int64
+	if m.upstreamErrors != nil {
+		upstreamErrors = m.upstreamErrors()
+	}
+	fmt.Fprintf(&b, "tiny_web_server_upstream_errors_total %d\n", upstreamErrors)
+
+	b.WriteString("# HELP tiny_web_server_active_connections Currently open client connections.\n")
+	b.WriteString("# TYPE tiny_web_server_active_connections gauge\n")
+	var openConnections int32
+	if m.openConnections != nil {
+		openConnections = m.openConnections()
+	}
+	fmt.Fprintf(&b, "tiny_web_server_active_connections %d\n", openConnections)
+
+	ctx.SetContentType("text/plain; version=0.0.4; charset=utf-8")
+	ctx.SetBodyString(b.String())
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+// staticImportRe matches static ES module imports and re-exports with a relative specifier:
+//
+//	import x from "./a.js"; import "./b.js"; export * from "../c.js"
+//
+// Dynamic import() calls are deliberately not matched, they are not part of the initial waterfall.
+var staticImportRe = regexp.MustCompile(`(?:^|[;\s}])(?:import|export)\s*(?:[\w*{}\s,$]+?\s*from\s*)?["'](\.{1,2}/[^"']+)["']`)
+
+type moduleInfo struct {
+	URL     string   `json:"url"`
+	Bytes   int64    `json:"bytes"`
+	Depth   int      `json:"depth"`
+	Imports []string `json:"imports"`
+}
+
+// moduleGraph is the static import graph of the served entry points, computed once at startup.
+type moduleGraph struct {
+	Entries    []string               `json:"entries"`
+	Modules    map[string]*moduleInfo `json:"modules"`
+	TotalBytes int64                  `json:"totalBytes"`
+	// MaxDepth is the depth of the most deeply nested module, i.e. the number of sequential
+	// round trips the browser needs to discover the whole graph without preload hints.
+	MaxDepth int `json:"maxDepth"`
+
+	links       []string
+	importMap   []byte
+	earlyHints  bool
+	htmlPrefix  string
+	entryByPath map[string]bool
+}
+
+// buildModuleGraph walks the static imports of the given entry URLs (e.g. "/components/index.js")
+// resolved against root.
+func buildModuleGraph(root string, entries []string) (*moduleGraph, error) {
+	g := &moduleGraph{
+		Entries:     entries,
+		Modules:     map[string]*moduleInfo{},
+		entryByPath: map[string]bool{},
+	}
+
+	// breadth-first, so each module gets the depth at which the browser discovers it first
+	queue := []string{}
+	for _, entry := range entries {
+		if !g.entryByPath[entry] {
+			g.entryByPath[entry] = true
+			queue = append(queue, entry)
+			g.Modules[entry] = &moduleInfo{URL: entry, Depth: 1}
+		}
+	}
+
+	for len(queue) > 0 {
+		m := g.Modules[queue[0]]
+		queue = queue[1:]
+
+		source, err := os.ReadFile(filepath.Join(root, filepath.FromSlash(m.URL)))
+		if err != nil {
+			return nil, fmt.Errorf("read module %s: %w", m.URL, err)
+		}
+		m.Bytes = int64(len(source))
+		m.Imports = []string{}
+
+		for _, match := range staticImportRe.FindAllSubmatch(source, -1) {
+			dep := path.Join(path.Dir(m.URL), string(match[1]))
+			if slices.Contains(m.Imports, dep) {
+				continue
+			}
+			m.Imports = append(m.Imports, dep)
+			if _, seen := g.Modules[dep]; !seen {
+				g.Modules[dep] = &moduleInfo{URL: dep, Depth: m.Depth + 1}
+				queue = append(queue, dep)
+			}
+		}
+	}
+
+	urls := make([]string, 0, len(g.Modules))
+	for url, m := range g.Modules {
+		urls = append(urls, url)
+		g.TotalBytes += m.Bytes
+		g.MaxDepth = max(g.MaxDepth, m.Depth)
+	}
+	// shallow modules first, so the browser requests them in the order it would discover them
+	slices.SortFunc(urls, func(a, b string) int {
+		if d := g.Modules[a].Depth - g.Modules[b].Depth; d != 0 {
+			return d
+		}
+		return strings.Compare(a, b)
+	})
+	for _, url := range urls {
+		g.links = append(g.links, fmt.Sprintf("<%s>; rel=modulepreload", url))
+	}
+
+	return g, nil
+}
+
+// generateImportMap maps each entry's bare name (e.g. "index", "tigerBackend") to its URL so test pages
+// can import them without hard-coding the /components prefix.
+func (g *moduleGraph) generateImportMap(prefix string) error {
+	imports := map[string]string{}
+	for _, entry := range g.Entries {
+		name := strings.TrimSuffix(path.Base(entry), path.Ext(entry))
+		imports[prefix+name] = entry
+	}
+	importMap, err := json.MarshalIndent(map[string]any{"imports": imports}, "", "  ")
+	if err != nil {
+		return err
+	}
+	g.importMap = importMap
+	return nil
+}
+
+// applyHints adds modulepreload Link headers to the test pages and the entry modules themselves.
+// It returns true when the response has already been handled.
+func (g *moduleGraph) applyHints(ctx *fasthttp.RequestCtx) bool {
+	p := string(ctx.Path())
+
+	switch {
+	case p == "/__modulegraph":
+		graphJSON, err := json.MarshalIndent(g, "", "  ")
+		if err != nil {
+			ctx.Error(fmt.Sprintf("module graph error: %v", err), fasthttp.StatusInternalServerError)
+			return true
+		}
+		ctx.SetContentType("application/json")
+		ctx.SetBody(graphJSON)
+		return true
+	case p == "/__importmap.json" && g.importMap != nil:
+		ctx.SetContentType("application/importmap+json")
+		ctx.SetBody(g.importMap)
+		return true
+	case strings.HasPrefix(p, g.htmlPrefix) && strings.HasSuffix(p, ".html"), g.entryByPath[p]:
+		for _, link := range g.links {
+			if link != "<"+p+">; rel=modulepreload" {
+				ctx.Response.Header.Add(fasthttp.HeaderLink, link)
+			}
+		}
+		if g.earlyHints && strings.HasSuffix(p, ".html") {
+			if err := ctx.EarlyHints(); err != nil {
+				log.Printf("Failed to send early hints for %s: %v", p, err)
+			}
+		}
+	}
+	return false
+}
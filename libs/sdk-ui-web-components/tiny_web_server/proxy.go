@@ -0,0 +1,280 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/valyala/fasthttp"
+)
+
+// proxyTLSOptions describes how the upstream (PROXY_HOST) certificate is trusted.
+type proxyTLSOptions struct {
+	CAFile     string // PEM bundle added to the system roots
+	PinSHA256  string // hex SHA-256 fingerprint of the upstream leaf certificate
+	Insecure   bool   // accept any certificate, loudly
+	ClientCert string // PEM client certificate for mTLS
+	ClientKey  string // PEM client key for mTLS
+}
+
+func loadProxyTLSOptions() (proxyTLSOptions, error) {
+	insecure := false
+	if v := os.Getenv("PROXY_INSECURE"); v != "" {
+		var err error
+		if insecure, err = strconv.ParseBool(v); err != nil {
+			return proxyTLSOptions{}, fmt.Errorf("invalid PROXY_INSECURE %q, expected true or false", v)
+		}
+	}
+
+	return proxyTLSOptions{
+		CAFile:     os.Getenv("PROXY_CA_FILE"),
+		PinSHA256:  os.Getenv("PROXY_PIN_SHA256"),
+		Insecure:   insecure,
+		ClientCert: os.Getenv("PROXY_CLIENT_CERT"),
+		ClientKey:  os.Getenv("PROXY_CLIENT_KEY"),
+	}, nil
+}
+
+// normalizeFingerprint accepts "AB:CD:..." as printed by openssl as well as plain hex.
+func normalizeFingerprint(fp string) (string, error) {
+	fp = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(fp), ":", ""))
+	b, err := hex.DecodeString(fp)
+	if err != nil || len(b) != sha256.Size {
+		return "", fmt.Errorf("expected %d-byte hex SHA-256 fingerprint, got %q", sha256.Size, fp)
+	}
+	return fp, nil
+}
+
+func buildProxyTLSConfig(opts proxyTLSOptions) (*tls.Config, error) {
+	cfg := &tls.Config{}
+
+	if opts.Insecure && (opts.CAFile != "" || opts.PinSHA256 != "") {
+		return nil, errors.New("PROXY_INSECURE cannot be combined with PROXY_CA_FILE or PROXY_PIN_SHA256")
+	}
+
+	if opts.CAFile != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		pemBytes, err := os.ReadFile(opts.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read PROXY_CA_FILE: %w", err)
+		}
+		if !pool.AppendCertsFromPEM(pemBytes) {
+			return nil, fmt.Errorf("no certificates found in PROXY_CA_FILE %q", opts.CAFile)
+		}
+		cfg.RootCAs = pool
+	}
+
+	if opts.PinSHA256 != "" {
+		pin, err := normalizeFingerprint(opts.PinSHA256)
+		if err != nil {
+			return nil, fmt.Errorf("invalid PROXY_PIN_SHA256: %w", err)
+		}
+		// Without PROXY_CA_FILE a pinned leaf is trusted on its own, the fingerprint check replaces chain
+		// verification. With it, the chain must also verify against the pool.
+		roots := cfg.RootCAs
+		cfg.InsecureSkipVerify = true
+		cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+			return verifyPinnedConnection(cs, pin, roots)
+		}
+	}
+
+	if opts.Insecure {
+		cfg.InsecureSkipVerify = true
+	}
+
+	if opts.ClientCert != "" || opts.ClientKey != "" {
+		if opts.ClientCert == "" || opts.ClientKey == "" {
+			return nil, errors.New("PROXY_CLIENT_CERT and PROXY_CLIENT_KEY must be set together")
+		}
+		cert, err := tls.LoadX509KeyPair(opts.ClientCert, opts.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	return cfg, nil
+}
+
+// verifyPinnedConnection checks the upstream leaf against the pin and, when roots is not nil, the chain
+// and the hostname against roots.
+func verifyPinnedConnection(cs tls.ConnectionState, pin string, roots *x509.CertPool) error {
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("upstream presented no certificate")
+	}
+	leaf := cs.PeerCertificates[0]
+	sum := sha256.Sum256(leaf.Raw)
+	if got := hex.EncodeToString(sum[:]); got != pin {
+		return fmt.Errorf("upstream certificate fingerprint %s does not match PROXY_PIN_SHA256", got)
+	}
+	if roots == nil {
+		return nil
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range cs.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+	if _, err := leaf.Verify(x509.VerifyOptions{DNSName: cs.ServerName, Roots: roots, Intermediates: intermediates}); err != nil {
+		return fmt.Errorf("upstream certificate matches PROXY_PIN_SHA256 but not PROXY_CA_FILE: %w", err)
+	}
+	return nil
+}
+
+func newProxyClient(opts proxyTLSOptions) *fasthttp.Client {
+	tlsConfig, err := buildProxyTLSConfig(opts)
+	if err != nil {
+		log.Fatalf("Invalid proxy TLS configuration: %v", err)
+	}
+
+	switch {
+	case opts.Insecure:
+		log.Printf("WARNING: PROXY_INSECURE is set, upstream TLS certificates are NOT verified.")
+		log.Printf("WARNING: any certificate presented by PROXY_HOST will be accepted, do not use this against shared environments.")
+	case opts.PinSHA256 != "" && opts.CAFile != "":
+		fmt.Printf("Upstream certificate pinned to SHA-256 %s and verified against system roots and %s\n", opts.PinSHA256, opts.CAFile)
+	case opts.PinSHA256 != "":
+		fmt.Printf("Upstream certificate pinned to SHA-256 %s\n", opts.PinSHA256)
+	case opts.CAFile != "":
+		fmt.Printf("Trusting upstream certificates from system roots and %s\n", opts.CAFile)
+	default:
+		fmt.Printf("Trusting upstream certificates from system roots\n")
+	}
+	if len(tlsConfig.Certificates) > 0 {
+		fmt.Printf("Presenting client certificate %s to upstream\n", opts.ClientCert)
+	}
+
+	return &fasthttp.Client{
+		TLSConfig: tlsConfig,
+	}
+}
+
+// proxy forwards /components/* to PROXY_HOST, optionally through the disk cache.
+type proxy struct {
+	host       string
+	hostHeader string
+	client     *fasthttp.Client
+	cache      *diskCache // nil when caching is disabled
+	rewriter   *rewriter  // nil when responses are passed through as they are
+
+	errors atomic.Int64 // requests that failed to reach upstream
+}
+
+func (p *proxy) forward(ctx *fasthttp.RequestCtx, resp *fasthttp.Response, prepare func(req *fasthttp.Request)) error {
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+
+	ctx.Request.CopyTo(req)
+	req.SetRequestURI(p.host + string(ctx.URI().RequestURI()))
+	req.Header.SetHost(p.hostHeader)
+	if prepare != nil {
+		prepare(req)
+	}
+
+	return p.client.Do(req, resp)
+}
+
+func (p *proxy) handle(ctx *fasthttp.RequestCtx) {
+	p.serve(ctx)
+	if p.rewriter != nil {
+		p.rewriter.apply(ctx)
+	}
+}
+
+func (p *proxy) serve(ctx *fasthttp.RequestCtx) {
+	if p.cache != nil && ctx.IsGet() {
+		p.handleCached(ctx)
+		return
+	}
+
+	if p.cache != nil && p.cache.offline {
+		ctx.Error("offline: only cached GET requests are served", fasthttp.StatusGatewayTimeout)
+		return
+	}
+
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+
+	if err := p.forward(ctx, resp, nil); err != nil {
+		p.errors.Add(1)
+		ctx.SetStatusCode(fasthttp.StatusBadGateway)
+		ctx.SetBodyString(fmt.Sprintf("proxy error: %v", err))
+		return
+	}
+	resp.CopyTo(&ctx.Response)
+}
+
+func (p *proxy) handleCached(ctx *fasthttp.RequestCtx) {
+	c := p.cache
+	uri := p.host + string(ctx.URI().RequestURI())
+	key := c.key(uri, ctx.Request.Header.Peek(fasthttp.HeaderAcceptEncoding))
+
+	entry, body, ok := c.load(key)
+	if ok && (c.offline || c.fresh(entry)) {
+		c.hits.Add(1)
+		c.serve(ctx, entry, body, "HIT")
+		return
+	}
+	if c.offline {
+		c.offlineMisses.Add(1)
+		ctx.Error(fmt.Sprintf("offline: %s is not cached", uri), fasthttp.StatusGatewayTimeout)
+		return
+	}
+
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+
+	err := p.forward(ctx, resp, func(req *fasthttp.Request) {
+		// the browser's own validators refer to its copy, not ours
+		req.Header.Del(fasthttp.HeaderIfNoneMatch)
+		req.Header.Del(fasthttp.HeaderIfModifiedSince)
+		if !ok {
+			return
+		}
+		if etag := entry.Header[fasthttp.HeaderETag]; etag != "" {
+			req.Header.Set(fasthttp.HeaderIfNoneMatch, etag)
+		}
+		if lastModified := entry.Header[fasthttp.HeaderLastModified]; lastModified != "" {
+			req.Header.Set(fasthttp.HeaderIfModifiedSince, lastModified)
+		}
+	})
+	if err != nil {
+		p.errors.Add(1)
+	}
+
+	switch {
+	case err != nil && ok:
+		log.Printf("proxy error for %s, serving stale cache entry: %v", uri, err)
+		c.staleServed.Add(1)
+		c.serve(ctx, entry, body, "STALE")
+	case err != nil:
+		ctx.SetStatusCode(fasthttp.StatusBadGateway)
+		ctx.SetBodyString(fmt.Sprintf("proxy error: %v", err))
+	case ok && resp.StatusCode() == fasthttp.StatusNotModified:
+		c.revalidated.Add(1)
+		if err := c.touch(key, entry); err != nil {
+			log.Printf("Failed to refresh cache entry for %s: %v", uri, err)
+		}
+		c.serve(ctx, entry, body, "REVALIDATED")
+	default:
+		c.misses.Add(1)
+		if resp.StatusCode() == fasthttp.StatusOK {
+			if err := c.store(key, uri, resp); err != nil {
+				log.Printf("Failed to cache %s: %v", uri, err)
+			}
+		}
+		resp.CopyTo(&ctx.Response)
+		ctx.Response.Header.Set("X-Cache", "MISS")
+	}
+}
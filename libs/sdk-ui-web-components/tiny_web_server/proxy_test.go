@@ -0,0 +1,115 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testCertificate(t *testing.T) (*x509.Certificate, []byte) {
+	t.Helper()
+	certPEM, _, err := generateSelfSignedCert()
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(certPEM)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, certPEM
+}
+
+func fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+func TestVerifyPinnedConnection(t *testing.T) {
+	upstream, _ := testCertificate(t)
+	other, _ := testCertificate(t)
+
+	upstreamRoots := x509.NewCertPool()
+	upstreamRoots.AddCert(upstream)
+	otherRoots := x509.NewCertPool()
+	otherRoots.AddCert(other)
+
+	tests := []struct {
+		name       string
+		pin        string
+		roots      *x509.CertPool
+		serverName string
+		wantErr    string
+	}{
+		{name: "pin only", pin: fingerprint(upstream), serverName: "localhost"},
+		{name: "pin and matching CA", pin: fingerprint(upstream), roots: upstreamRoots, serverName: "localhost"},
+		{name: "wrong pin", pin: fingerprint(other), serverName: "localhost", wantErr: "does not match PROXY_PIN_SHA256"},
+		{name: "pin and other CA", pin: fingerprint(upstream), roots: otherRoots, serverName: "localhost", wantErr: "not PROXY_CA_FILE"},
+		{name: "pin and CA with wrong host", pin: fingerprint(upstream), roots: upstreamRoots, serverName: "example.com", wantErr: "not PROXY_CA_FILE"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cs := tls.ConnectionState{ServerName: tt.serverName, PeerCertificates: []*x509.Certificate{upstream}}
+			err := verifyPinnedConnection(cs, tt.pin, tt.roots)
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestBuildProxyTLSConfigPinWithCAFile(t *testing.T) {
+	upstream, upstreamPEM := testCertificate(t)
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caFile, upstreamPEM, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := buildProxyTLSConfig(proxyTLSOptions{CAFile: caFile, PinSHA256: fingerprint(upstream)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.VerifyConnection == nil {
+		t.Fatal("pinned config has no VerifyConnection")
+	}
+	if err = cfg.VerifyConnection(tls.ConnectionState{ServerName: "localhost", PeerCertificates: []*x509.Certificate{upstream}}); err != nil {
+		t.Fatalf("pinned certificate from PROXY_CA_FILE rejected: %v", err)
+	}
+	if err = cfg.VerifyConnection(tls.ConnectionState{ServerName: "example.com", PeerCertificates: []*x509.Certificate{upstream}}); err == nil {
+		t.Fatal("pinned certificate for another host accepted, PROXY_CA_FILE was not used")
+	}
+}
+
+func TestLoadProxyTLSOptionsInsecure(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    bool
+		wantErr bool
+	}{
+		{value: "", want: false},
+		{value: "true", want: true},
+		{value: "0", want: false},
+		{value: "yes", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			t.Setenv("PROXY_INSECURE", tt.value)
+			opts, err := loadProxyTLSOptions()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error %v, want error %t", err, tt.wantErr)
+			}
+			if err == nil && opts.Insecure != tt.want {
+				t.Fatalf("Insecure = %t, want %t", opts.Insecure, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+// rewriteRule replaces From with To in proxied text bodies and Location headers. An empty To stands for
+// the dev origin the browser is talking to, e.g. https://localhost:3001.
+type rewriteRule struct {
+	From string
+	To   string
+}
+
+// rewriteRules implements flag.Value for repeated --rewrite=FROM=TO flags.
+type rewriteRules []rewriteRule
+
+func (r *rewriteRules) String() string {
+	parts := make([]string, 0, len(*r))
+	for _, rule := range *r {
+		parts = append(parts, rule.From+"="+rule.To)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (r *rewriteRules) Set(value string) error {
+	from, to, _ := strings.Cut(value, "=")
+	if from == "" {
+		return fmt.Errorf("expected FROM=TO, got %q", value)
+	}
+	*r = append(*r, rewriteRule{From: from, To: to})
+	return nil
+}
+
+// rewritableContentTypes are the proxied bodies that may contain absolute upstream URLs.
+var rewritableContentTypes = []string{
+	"text/",
+	"application/javascript",
+	"application/x-javascript",
+	"application/json",
+	"application/manifest+json",
+	"image/svg+xml",
+}
+
+// rewriter keeps proxied traffic on the dev origin: it substitutes hosts in text bodies, rewrites
+// redirect Locations and makes upstream cookies valid for the dev host.
+type rewriter struct {
+	rules   []rewriteRule
+	cookies bool
+}
+
+func (rw *rewriter) replacer(devOrigin string) *strings.Replacer {
+	pairs := make([]string, 0, 2*len(rw.rules))
+	for _, rule := range rw.rules {
+		to := rule.To
+		if to == "" {
+			to = devOrigin
+		}
+		pairs = append(pairs, rule.From, to)
+	}
+	return strings.NewReplacer(pairs...)
+}
+
+func (rw *rewriter) apply(ctx *fasthttp.RequestCtx) {
+	devOrigin := "https://" + string(ctx.Host())
+	replacer := rw.replacer(devOrigin)
+	resp := &ctx.Response
+
+	if location := resp.Header.Peek(fasthttp.HeaderLocation); len(location) > 0 {
+		resp.Header.Set(fasthttp.HeaderLocation, replacer.Replace(string(location)))
+	}
+
+	if rw.cookies {
+		rewriteCookies(resp)
+	}
+
+	if len(rw.rules) == 0 || !isRewritable(resp.Header.ContentType()) {
+		return
+	}
+	if err := rewriteBody(resp, replacer); err != nil {
+		log.Printf("Failed to rewrite %s: %v", ctx.RequestURI(), err)
+	}
+}
+
+func isRewritable(contentType []byte) bool {
+	for _, prefix := range rewritableContentTypes {
+		if bytes.HasPrefix(contentType, []byte(prefix)) {
+			return true
+		}
+	}
+	return false
+}
+
+// rewriteBody decodes the body according to Content-Encoding, applies the replacer and encodes it back
+// with the same encoding, so the browser gets what the headers promise.
+func rewriteBody(resp *fasthttp.Response, replacer *strings.Replacer) error {
+	encoding := string(resp.Header.ContentEncoding())
+
+	var body []byte
+	var err error
+	switch encoding {
+	case "":
+		body = resp.Body()
+	case "gzip":
+		body, err = resp.BodyGunzip()
+	case "br":
+		body, err = resp.BodyUnbrotli()
+	case "deflate":
+		body, err = resp.BodyInflate()
+	case "zstd":
+		body, err = resp.BodyUnzstd()
+	default:
+		return fmt.Errorf("unsupported content encoding %q", encoding)
+	}
+	if err != nil {
+		return fmt.Errorf("decode %s body: %w", encoding, err)
+	}
+
+	rewritten := replacer.Replace(string(body))
+	if rewritten == string(body) {
+		return nil
+	}
+
+	switch encoding {
+	case "":
+		resp.SetBodyString(rewritten)
+	case "gzip":
+		resp.SetBody(fasthttp.AppendGzipBytes(nil, []byte(rewritten)))
+	case "br":
+		resp.SetBody(fasthttp.AppendBrotliBytes(nil, []byte(rewritten)))
+	case "deflate":
+		resp.SetBody(fasthttp.AppendDeflateBytes(nil, []byte(rewritten)))
+	case "zstd":
+		resp.SetBody(fasthttp.AppendZstdBytes(nil, []byte(rewritten)))
+	}
+
+	// the upstream validator no longer describes these bytes exactly
+	if etag := resp.Header.Peek(fasthttp.HeaderETag); len(etag) > 0 && !bytes.HasPrefix(etag, []byte("W/")) {
+		resp.Header.Set(fasthttp.HeaderETag, "W/"+string(etag))
+	}
+	return nil
+}
+
+// rewriteCookies drops the Domain attribute of upstream cookies, so they become host-only cookies
+// of the dev host, and marks them Secure as the dev server is HTTPS only.
+func rewriteCookies(resp *fasthttp.Response) {
+	var cookies []*fasthttp.Cookie
+	resp.Header.VisitAllCookie(func(key, value []byte) {
+		c := fasthttp.AcquireCookie()
+		if err := c.ParseBytes(value); err != nil {
+			fasthttp.ReleaseCookie(c)
+			return
+		}
+		cookies = append(cookies, c)
+	})
+	if len(cookies) == 0 {
+		return
+	}
+
+	resp.Header.DelAllCookies()
+	for _, c := range cookies {
+		c.SetDomain("")
+		c.SetSecure(true)
+		resp.Header.SetCookie(c)
+		fasthttp.ReleaseCookie(c)
+	}
+}
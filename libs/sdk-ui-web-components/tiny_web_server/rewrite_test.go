@@ -0,0 +1,80 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestRewriteBody(t *testing.T) {
+	const upstream = `import "https://staging.example.com/components/a.js";`
+	const rewritten = `import "https://localhost:3001/components/a.js";`
+	rw := &rewriter{rules: []rewriteRule{{From: "https://staging.example.com"}}}
+	replacer := rw.replacer("https://localhost:3001")
+
+	tests := []struct {
+		encoding string
+		encode   func([]byte) []byte
+		decode   func(*fasthttp.Response) ([]byte, error)
+	}{
+		{encoding: "", encode: func(b []byte) []byte { return b }, decode: func(r *fasthttp.Response) ([]byte, error) { return r.Body(), nil }},
+		{encoding: "gzip", encode: func(b []byte) []byte { return fasthttp.AppendGzipBytes(nil, b) }, decode: (*fasthttp.Response).BodyGunzip},
+		{encoding: "br", encode: func(b []byte) []byte { return fasthttp.AppendBrotliBytes(nil, b) }, decode: (*fasthttp.Response).BodyUnbrotli},
+		{encoding: "deflate", encode: func(b []byte) []byte { return fasthttp.AppendDeflateBytes(nil, b) }, decode: (*fasthttp.Response).BodyInflate},
+		{encoding: "zstd", encode: func(b []byte) []byte { return fasthttp.AppendZstdBytes(nil, b) }, decode: (*fasthttp.Response).BodyUnzstd},
+	}
+	for _, tt := range tests {
+		t.Run("encoding "+tt.encoding, func(t *testing.T) {
+			resp := &fasthttp.Response{}
+			if tt.encoding != "" {
+				resp.Header.Set(fasthttp.HeaderContentEncoding, tt.encoding)
+			}
+			resp.Header.Set(fasthttp.HeaderETag, `"v1"`)
+			resp.SetBody(tt.encode([]byte(upstream)))
+
+			if err := rewriteBody(resp, replacer); err != nil {
+				t.Fatal(err)
+			}
+			body, err := tt.decode(resp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(body) != rewritten {
+				t.Errorf("body = %q, want %q", body, rewritten)
+			}
+			if etag := string(resp.Header.Peek(fasthttp.HeaderETag)); etag != `W/"v1"` {
+				t.Errorf("ETag = %q, want it weakened", etag)
+			}
+		})
+	}
+}
+
+func TestRewriteBodyUnchanged(t *testing.T) {
+	resp := &fasthttp.Response{}
+	resp.Header.Set(fasthttp.HeaderETag, `"v1"`)
+	resp.SetBodyString("no upstream urls here")
+
+	rw := &rewriter{rules: []rewriteRule{{From: "https://staging.example.com"}}}
+	if err := rewriteBody(resp, rw.replacer("https://localhost:3001")); err != nil {
+		t.Fatal(err)
+	}
+	if etag := string(resp.Header.Peek(fasthttp.HeaderETag)); etag != `"v1"` {
+		t.Errorf("ETag of an untouched body = %q, want it kept strong", etag)
+	}
+}
+
+func TestRewriteBodyUnsupportedEncoding(t *testing.T) {
+	resp := &fasthttp.Response{}
+	resp.Header.Set(fasthttp.HeaderContentEncoding, "compress")
+	resp.SetBodyString("https://staging.example.com")
+
+	rw := &rewriter{rules: []rewriteRule{{From: "https://staging.example.com"}}}
+	err := rewriteBody(resp, rw.replacer("https://localhost:3001"))
+	if err == nil || !strings.Contains(err.Error(), "unsupported content encoding") {
+		t.Fatalf("error %v, want unsupported content encoding", err)
+	}
+	if string(resp.Body()) != "https://staging.example.com" {
+		t.Errorf("body of an unsupported encoding changed to %q", resp.Body())
+	}
+}
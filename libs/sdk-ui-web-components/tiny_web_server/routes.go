@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+const configScriptPath = "/web-components/config.js"
+
+// routePreset is a named workspace/dashboard pair, defined as PRESET_<NAME>=<workspaceId>/<dashboardId>
+// in .env or the environment.
+type routePreset struct {
+	WorkspaceId string `json:"workspaceId"`
+	DashboardId string `json:"dashboardId"`
+}
+
+func loadPresets() (map[string]routePreset, error) {
+	values := map[string]string{}
+	for _, kv := range os.Environ() {
+		if key, value, ok := strings.Cut(kv, "="); ok && strings.HasPrefix(key, "PRESET_") {
+			values[key] = value
+		}
+	}
+	// .env wins over the environment, same as for the other settings
+	for key, value := range readDotEnv() {
+		if strings.HasPrefix(key, "PRESET_") {
+			values[key] = value
+		}
+	}
+
+	presets := map[string]routePreset{}
+	for key, value := range values {
+		name := strings.ToLower(strings.TrimPrefix(key, "PRESET_"))
+		workspaceId, dashboardId, ok := strings.Cut(value, "/")
+		if name == "" || !ok || workspaceId == "" || dashboardId == "" {
+			return nil, fmt.Errorf("invalid %s=%q, expected <workspaceId>/<dashboardId>", key, value)
+		}
+		presets[name] = routePreset{WorkspaceId: workspaceId, DashboardId: dashboardId}
+	}
+	return presets, nil
+}
+
+// virtualRoutes serves the test pages under
+//
+//	/w/{workspaceId}/d/{dashboardId}/...   e.g. /w/demo/d/abc/web-components/dashboard-test.html
+//	/p/{preset}/...                        e.g. /p/sales/web-components/dashboard-test.html
+//
+// with a config.js generated from the path, so parallel tests can target different workspaces and
+// dashboards through a single server.
+type virtualRoutes struct {
+	base    envConfig
+	presets map[string]routePreset
+}
+
+type routeMatch struct {
+	prefix string
+	rest   string
+	config envConfig
+}
+
+func (v *virtualRoutes) match(requestPath string) (*routeMatch, error) {
+	segments := strings.SplitN(strings.TrimPrefix(requestPath, "/"), "/", 5)
+	cfg := v.base
+
+	var prefixSegments int
+	switch {
+	case len(segments) >= 4 && segments[0] == "w" && segments[2] == "d":
+		cfg.WorkspaceId, cfg.DashboardId = segments[1], segments[3]
+		prefixSegments = 4
+	case len(segments) >= 2 && segments[0] == "p":
+		preset, ok := v.presets[strings.ToLower(segments[1])]
+		if !ok {
+			return nil, fmt.Errorf("unknown preset %q", segments[1])
+		}
+		cfg.WorkspaceId, cfg.DashboardId = preset.WorkspaceId, preset.DashboardId
+		prefixSegments = 2
+	default:
+		return nil, nil
+	}
+
+	if cfg.WorkspaceId == "" || cfg.DashboardId == "" {
+		return nil, fmt.Errorf("empty workspace or dashboard id in %q", requestPath)
+	}
+
+	prefix := "/" + strings.Join(segments[:prefixSegments], "/")
+	rest := strings.TrimPrefix(requestPath, prefix)
+	if rest == "" {
+		rest = "/"
+	}
+	return &routeMatch{prefix: prefix, rest: rest, config: cfg}, nil
+}
+
+// serve handles a matched route: config.js is generated, everything else is served by next under the
+// path without the route prefix. Test pages get their config.js reference pointed back into the route.
+func (v *virtualRoutes) serve(ctx *fasthttp.RequestCtx, m *routeMatch, next fasthttp.RequestHandler) {
+	if m.rest == configScriptPath {
+		cfgJSON, err := json.MarshalIndent(m.config, "", "  ")
+		if err != nil {
+			ctx.Error(fmt.Sprintf("config error: %v", err), fasthttp.StatusInternalServerError)
+			return
+		}
+		ctx.SetContentType("text/javascript; charset=utf-8")
+		ctx.Response.Header.Set(fasthttp.HeaderCacheControl, "no-store")
+		ctx.SetBody(configScript("// Generated for "+m.prefix+"\n", cfgJSON))
+		return
+	}
+
+	isPage := strings.HasSuffix(m.rest, ".html")
+	if isPage {
+		requestIdentity(ctx)
+	}
+
+	ctx.Request.URI().SetPath(m.rest)
+	next(ctx)
+
+	if !isPage || ctx.Response.StatusCode() != fasthttp.StatusOK {
+		return
+	}
+	body := ctx.Response.Body()
+	routed := bytes.ReplaceAll(body, []byte(`"`+configScriptPath+`"`), []byte(`"`+m.prefix+configScriptPath+`"`))
+	ctx.SetBody(routed)
+	ctx.Response.Header.Del(fasthttp.HeaderETag)
+	ctx.Response.Header.Del(fasthttp.HeaderLastModified)
+}
+
+func (v *virtualRoutes) handlePresets(ctx *fasthttp.RequestCtx) {
+	presetsJSON, err := json.MarshalIndent(v.presets, "", "  ")
+	if err != nil {
+		ctx.Error(fmt.Sprintf("presets error: %v", err), fasthttp.StatusInternalServerError)
+		return
+	}
+	ctx.SetContentType("application/json")
+	ctx.SetBody(presetsJSON)
+}
+
+func (v *virtualRoutes) presetNames() []string {
+	return slices.Sorted(maps.Keys(v.presets))
+}
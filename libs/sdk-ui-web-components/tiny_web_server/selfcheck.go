@@ -0,0 +1,256 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/playwright-community/playwright-go"
+)
+
+// customElementsCheckScript waits until the page contains custom elements, then until each of them is
+// defined and has rendered something (shadow root or children with a non-empty box).
+const customElementsCheckScript = `async (timeout) => {
+    const deadline = Date.now() + timeout;
+    const sleep = (ms) => new Promise((resolve) => setTimeout(resolve, ms));
+    const names = () => [
+        ...new Set(
+            [...document.querySelectorAll("*")].map((el) => el.localName).filter((name) => name.includes("-")),
+        ),
+    ];
+    const inspect = (name) => {
+        const el = document.querySelector(name);
+        const rect = el.getBoundingClientRect();
+        return {
+            name,
+            defined: customElements.get(name) !== undefined,
+            rendered: rect.width > 0 && rect.height > 0 && (el.shadowRoot !== null || el.childElementCount > 0),
+        };
+    };
+
+    while (names().length === 0 && Date.now() < deadline) {
+        await sleep(100);
+    }
+    let elements = names().map(inspect);
+    while (elements.some((el) => !el.defined || !el.rendered) && Date.now() < deadline) {
+        await sleep(100);
+        elements = names().map(inspect);
+    }
+    return elements;
+}`
+
+type customElementState struct {
+	Name     string `json:"name"`
+	Defined  bool   `json:"defined"`
+	Rendered bool   `json:"rendered"`
+}
+
+type pageReport struct {
+	URL      string
+	Elements []customElementState
+	Errors   []string
+	Duration time.Duration
+}
+
+func (r pageReport) ok() bool {
+	if len(r.Errors) > 0 || len(r.Elements) == 0 {
+		return false
+	}
+	for _, el := range r.Elements {
+		if !el.Defined || !el.Rendered {
+			return false
+		}
+	}
+	return true
+}
+
+// testPages lists the .html test pages under static/web-components as URL paths.
+func testPages(staticDir string) ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(staticDir, "web-components", "*.html"))
+	if err != nil {
+		return nil, err
+	}
+	pages := make([]string, 0, len(files))
+	for _, f := range files {
+		pages = append(pages, "/web-components/"+filepath.Base(f))
+	}
+	sort.Strings(pages)
+	return pages, nil
+}
+
+func checkPage(browserContext playwright.BrowserContext, url string, timeout time.Duration) (report pageReport) {
+	report.URL = url
+	t0 := time.Now()
+
+	// console and page errors arrive on playwright's goroutines
+	var mu sync.Mutex
+	defer func() {
+		mu.Lock()
+		report.Errors = append([]string(nil), report.Errors...)
+		mu.Unlock()
+		report.Duration = time.Since(t0)
+	}()
+
+	page, err := browserContext.NewPage()
+	if err != nil {
+		report.Errors = append(report.Errors, fmt.Sprintf("could not create page: %v", err))
+		return report
+	}
+	defer page.Close()
+
+	page.OnConsole(func(msg playwright.ConsoleMessage) {
+		if msg.Type() == "error" {
+			mu.Lock()
+			report.Errors = append(report.Errors, "console error: "+msg.Text())
+			mu.Unlock()
+		}
+	})
+	page.OnPageError(func(err error) {
+		mu.Lock()
+		report.Errors = append(report.Errors, "uncaught exception: "+err.Error())
+		mu.Unlock()
+	})
+
+	if _, err = page.Goto(url, playwright.PageGotoOptions{
+		WaitUntil: playwright.WaitUntilStateLoad,
+		Timeout:   playwright.Float(float64(timeout.Milliseconds())),
+	}); err != nil {
+		mu.Lock()
+		report.Errors = append(report.Errors, fmt.Sprintf("could not load page: %v", err))
+		mu.Unlock()
+		return report
+	}
+
+	result, err := page.Evaluate(customElementsCheckScript, timeout.Milliseconds())
+	if err != nil {
+		mu.Lock()
+		report.Errors = append(report.Errors, fmt.Sprintf("could not inspect custom elements: %v", err))
+		mu.Unlock()
+		return report
+	}
+	resultJSON, err := json.Marshal(result)
+	if err == nil {
+		err = json.Unmarshal(resultJSON, &report.Elements)
+	}
+	if err != nil {
+		mu.Lock()
+		report.Errors = append(report.Errors, fmt.Sprintf("unexpected custom elements result: %v", err))
+		mu.Unlock()
+	}
+
+	return report
+}
+
+func printSelfcheckReport(reports []pageReport) {
+	fmt.Println()
+	fmt.Println("Selfcheck report")
+	for _, r := range reports {
+		status := "OK  "
+		if !r.ok() {
+			status = "FAIL"
+		}
+		fmt.Printf("%s %s (%s)\n", status, r.URL, r.Duration.Round(time.Millisecond))
+		if len(r.Elements) == 0 {
+			fmt.Printf("     no custom elements found on the page\n")
+		}
+		for _, el := range r.Elements {
+			fmt.Printf("     <%s> defined=%t rendered=%t\n", el.Name, el.Defined, el.Rendered)
+		}
+		for _, e := range r.Errors {
+			fmt.Printf("     %s\n", e)
+		}
+	}
+}
+
+// runSelfcheck starts the server in-process, loads every test page in headless Chromium and checks
+// that the custom elements are defined and render without console errors. It returns the exit code.
+func runSelfcheck(args []string) int {
+	fs := flag.NewFlagSet("selfcheck", flag.ExitOnError)
+	opts := registerServerFlags(fs)
+	pagesFlag := fs.String("pages", "", "comma-separated page paths to check (default: every .html in static/web-components)")
+	timeout := fs.Duration("timeout", 60*time.Second, "per-page timeout for loading and rendering")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	server, certPEM, keyPEM := newServer(opts)
+
+	ln, err := net.Listen("tcp", fmt.Sprintf(":%d", opts.port))
+	if err != nil {
+		log.Printf("Failed to listen on port %d: %v", opts.port, err)
+		return 1
+	}
+	go func() {
+		if err := server.ServeTLSEmbed(ln, certPEM, keyPEM); err != nil {
+			log.Printf("Server stopped: %v", err)
+		}
+	}()
+	defer server.Shutdown()
+
+	pages := strings.Split(*pagesFlag, ",")
+	if *pagesFlag == "" {
+		absFolder, err := filepath.Abs("./static/")
+		if err != nil {
+			log.Printf("Failed to get absolute path: %v", err)
+			return 1
+		}
+		if pages, err = testPages(absFolder); err != nil {
+			log.Printf("Failed to list test pages: %v", err)
+			return 1
+		}
+	}
+	if len(pages) == 0 {
+		log.Printf("No test pages found")
+		return 1
+	}
+
+	if err = playwright.Install(&playwright.RunOptions{Browsers: []string{"chromium"}}); err != nil {
+		log.Printf("could not install playwright drivers: %v", err)
+		return 1
+	}
+	pw, err := playwright.Run()
+	if err != nil {
+		log.Printf("could not start playwright: %v", err)
+		return 1
+	}
+	defer pw.Stop()
+
+	browser, err := pw.Chromium.Launch(playwright.BrowserTypeLaunchOptions{Headless: playwright.Bool(true)})
+	if err != nil {
+		log.Printf("could not launch browser: %v", err)
+		return 1
+	}
+	defer browser.Close()
+
+	browserContext, err := browser.NewContext(playwright.BrowserNewContextOptions{
+		// the server certificate is self-signed
+		IgnoreHttpsErrors: playwright.Bool(true),
+	})
+	if err != nil {
+		log.Printf("could not create context: %v", err)
+		return 1
+	}
+
+	reports := make([]pageReport, 0, len(pages))
+	for _, p := range pages {
+		url := fmt.Sprintf("https://localhost:%d%s", opts.port, p)
+		fmt.Println("Checking", url)
+		reports = append(reports, checkPage(browserContext, url, *timeout))
+	}
+
+	printSelfcheckReport(reports)
+
+	for _, r := range reports {
+		if !r.ok() {
+			return 1
+		}
+	}
+	return 0
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// precompressedSuffixes maps content encodings to the suffix of prebuilt sibling files, in preference order.
+var precompressedSuffixes = []struct {
+	encoding string
+	suffix   string
+}{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+type fileDigest struct {
+	modTime time.Time
+	size    int64
+	sum     string
+}
+
+// staticHandler serves files from root with on-the-fly gzip/brotli compression, prebuilt .br/.gz siblings
+// and strong ETags. Compressed copies generated on the fly are kept outside root.
+type staticHandler struct {
+	root       string
+	fsHandler  fasthttp.RequestHandler
+	rawHandler fasthttp.RequestHandler
+
+	digests sync.Map // absolute file path -> fileDigest
+}
+
+func newStaticHandler(root string) *staticHandler {
+	fs := &fasthttp.FS{
+		Root:               root,
+		IndexNames:         []string{"index.html"},
+		GenerateIndexPages: false,
+		AcceptByteRange:    true,
+		Compress:           true,
+		CompressBrotli:     true,
+		CompressRoot:       filepath.Join(os.TempDir(), "tiny_web_server", "compressed"),
+	}
+	// raw serves the prebuilt siblings as they are, they are already encoded
+	raw := &fasthttp.FS{
+		Root:               root,
+		GenerateIndexPages: false,
+	}
+
+	return &staticHandler{
+		root:       root,
+		fsHandler:  fs.NewRequestHandler(),
+		rawHandler: raw.NewRequestHandler(),
+	}
+}
+
+// filePath resolves the request path to a file under root, following the index.html convention.
+func (h *staticHandler) filePath(requestPath string) string {
+	p := filepath.Join(h.root, filepath.FromSlash(path.Clean("/"+requestPath)))
+	if info, err := os.Stat(p); err == nil && info.IsDir() {
+		p = filepath.Join(p, "index.html")
+	}
+	return p
+}
+
+func (h *staticHandler) digest(filePath string) (string, bool) {
+	info, err := os.Stat(filePath)
+	if err != nil || info.IsDir() {
+		return "", false
+	}
+	if cached, ok := h.digests.Load(filePath); ok {
+		d := cached.(fileDigest)
+		if d.modTime.Equal(info.ModTime()) && d.size == info.Size() {
+			return d.sum, true
+		}
+	}
+
+	f, err := os.Open(filePath)
+	if err != nil {
+		return "", false
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", false
+	}
+	sum := hex.EncodeToString(hash.Sum(nil))[:32]
+	h.digests.Store(filePath, fileDigest{modTime: info.ModTime(), size: info.Size(), sum: sum})
+
+	return sum, true
+}
+
+// precompressed returns the encoding and suffix of a prebuilt sibling the client accepts, if one exists
+// and is not older than the original.
+func (h *staticHandler) precompressed(ctx *fasthttp.RequestCtx, filePath string) (string, string, bool) {
+	original, err := os.Stat(filePath)
+	if err != nil {
+		return "", "", false
+	}
+	for _, p := range precompressedSuffixes {
+		if !ctx.Request.Header.HasAcceptEncoding(p.encoding) {
+			continue
+		}
+		sibling, err := os.Stat(filePath + p.suffix)
+		if err != nil || sibling.ModTime().Before(original.ModTime()) {
+			continue
+		}
+		return p.encoding, p.suffix, true
+	}
+	return "", "", false
+}
+
+func (h *staticHandler) handle(ctx *fasthttp.RequestCtx) {
+	requestPath := string(ctx.Path())
+	filePath := h.filePath(requestPath)
+
+	if encoding, suffix, ok := h.precompressed(ctx, filePath); ok {
+		relPath, _ := filepath.Rel(h.root, filePath)
+		ctx.Request.Header.Del(fasthttp.HeaderRange)
+		ctx.Request.URI().SetPath("/" + filepath.ToSlash(relPath) + suffix)
+		h.rawHandler(ctx)
+		ctx.Request.URI().SetPath(requestPath)
+
+		if ctx.Response.StatusCode() == fasthttp.StatusOK {
+			contentType := mime.TypeByExtension(filepath.Ext(filePath))
+			if contentType == "" {
+				contentType = "application/octet-stream"
+			}
+			ctx.SetContentType(contentType)
+			ctx.Response.Header.Set(fasthttp.HeaderContentEncoding, encoding)
+			ctx.Response.Header.Set(fasthttp.HeaderVary, "Accept-Encoding")
+		}
+	} else {
+		h.fsHandler(ctx)
+	}
+
+	h.applyETag(ctx, filePath)
+}
+
+// applyETag sets a strong ETag derived from the file content and the response encoding and turns
+// the response into 304 Not Modified when the client already has it.
+func (h *staticHandler) applyETag(ctx *fasthttp.RequestCtx, filePath string) {
+	status := ctx.Response.StatusCode()
+	if status != fasthttp.StatusOK && status != fasthttp.StatusNotModified {
+		return
+	}
+	sum, ok := h.digest(filePath)
+	if !ok {
+		return
+	}
+
+	etag := sum
+	if encoding := ctx.Response.Header.ContentEncoding(); len(encoding) > 0 {
+		etag += "-" + string(encoding)
+	}
+	etag = `"` + etag + `"`
+	ctx.Response.Header.Set(fasthttp.HeaderETag, etag)
+
+	if etagMatches(ctx.Request.Header.Peek(fasthttp.HeaderIfNoneMatch), etag) {
+		ctx.Response.ResetBody()
+		ctx.Response.SkipBody = true
+		ctx.Response.Header.Del(fasthttp.HeaderContentRange)
+		ctx.SetStatusCode(fasthttp.StatusNotModified)
+	}
+}
+
+// etagMatches compares If-None-Match with etag the weak way RFC 9110 prescribes for it: W/ prefixes are
+// ignored, the header may list several tags and "*" matches any.
+func etagMatches(ifNoneMatch []byte, etag string) bool {
+	if len(ifNoneMatch) == 0 {
+		return false
+	}
+	if bytes.Equal(bytes.TrimSpace(ifNoneMatch), []byte("*")) {
+		return true
+	}
+	for _, candidate := range strings.Split(string(ifNoneMatch), ",") {
+		if strings.TrimPrefix(strings.TrimSpace(candidate), "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// requestIdentity asks the handlers for the complete, uncompressed representation, so the body can be
+// modified after they ran.
+func requestIdentity(ctx *fasthttp.RequestCtx) {
+	ctx.Request.Header.Del(fasthttp.HeaderAcceptEncoding)
+	ctx.Request.Header.Del(fasthttp.HeaderRange)
+	ctx.Request.Header.Del(fasthttp.HeaderIfNoneMatch)
+	ctx.Request.Header.Del(fasthttp.HeaderIfModifiedSince)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestEtagMatches(t *testing.T) {
+	const etag = `"abc-br"`
+	tests := []struct {
+		ifNoneMatch string
+		want        bool
+	}{
+		{ifNoneMatch: "", want: false},
+		{ifNoneMatch: `"abc-br"`, want: true},
+		{ifNoneMatch: `W/"abc-br"`, want: true},
+		{ifNoneMatch: `"abc"`, want: false},
+		{ifNoneMatch: `"abc-gzip"`, want: false},
+		{ifNoneMatch: `"x", W/"abc-br"`, want: true},
+		{ifNoneMatch: `"x","y"`, want: false},
+		{ifNoneMatch: "*", want: true},
+		{ifNoneMatch: " * ", want: true},
+		{ifNoneMatch: `abc-br`, want: false},
+	}
+	for _, tt := range tests {
+		if got := etagMatches([]byte(tt.ifNoneMatch), etag); got != tt.want {
+			t.Errorf("etagMatches(%q, %q) = %t, want %t", tt.ifNoneMatch, etag, got, tt.want)
+		}
+	}
+}
+
+func serveStatic(h *staticHandler, requestPath string, headers map[string]string) *fasthttp.Response {
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI(requestPath)
+	for k, v := range headers {
+		ctx.Request.Header.Set(k, v)
+	}
+	h.handle(ctx)
+	return &ctx.Response
+}
+
+func TestStaticHandlerETagAndPrecompressed(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "app.js"), []byte("console.log(1);"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "app.js.br"), []byte("prebuilt brotli"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// the sibling must not be older than the original
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(filepath.Join(root, "app.js.br"), later, later); err != nil {
+		t.Fatal(err)
+	}
+	h := newStaticHandler(root)
+
+	plain := serveStatic(h, "/app.js", nil)
+	if plain.StatusCode() != fasthttp.StatusOK || string(plain.Body()) != "console.log(1);" {
+		t.Fatalf("plain: %d %q", plain.StatusCode(), plain.Body())
+	}
+	plainETag := string(plain.Header.Peek(fasthttp.HeaderETag))
+
+	br := serveStatic(h, "/app.js", map[string]string{fasthttp.HeaderAcceptEncoding: "br"})
+	if string(br.Header.ContentEncoding()) != "br" || string(br.Body()) != "prebuilt brotli" {
+		t.Fatalf("br: encoding %q body %q", br.Header.ContentEncoding(), br.Body())
+	}
+	brETag := string(br.Header.Peek(fasthttp.HeaderETag))
+	if plainETag == "" || brETag == plainETag {
+		t.Fatalf("ETags %q and %q must differ per encoding", plainETag, brETag)
+	}
+
+	notModified := serveStatic(h, "/app.js", map[string]string{fasthttp.HeaderIfNoneMatch: plainETag})
+	if notModified.StatusCode() != fasthttp.StatusNotModified || len(notModified.Body()) != 0 {
+		t.Fatalf("revalidation: %d with %d bytes", notModified.StatusCode(), len(notModified.Body()))
+	}
+	// the brotli tag does not validate the identity representation
+	if resp := serveStatic(h, "/app.js", map[string]string{fasthttp.HeaderIfNoneMatch: brETag}); resp.StatusCode() != fasthttp.StatusOK {
+		t.Fatalf("identity revalidated with the br ETag: %d", resp.StatusCode())
+	}
+}
+
+// staticChoices are the files and the expected responses in testdata/static_choices.json. The serve image
+// of sdk-ui-tests-storybook forks the ETag and precompressed helpers and checks itself against the same
+// file, so both copies answer the same.
+type staticChoices struct {
+	Files    map[string]string `json:"files"`
+	Stale    []string          `json:"stale"`
+	Requests []struct {
+		Path           string `json:"path"`
+		AcceptEncoding string `json:"acceptEncoding"`
+		IfNoneMatch    string `json:"ifNoneMatch"`
+		Status         int    `json:"status"`
+		Encoding       string `json:"encoding"`
+		ETag           string `json:"etag"`
+	} `json:"requests"`
+}
+
+func TestStaticHandlerChoices(t *testing.T) {
+	raw, err := os.ReadFile(filepath.Join("testdata", "static_choices.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var choices staticChoices
+	if err := json.Unmarshal(raw, &choices); err != nil {
+		t.Fatal(err)
+	}
+
+	root := t.TempDir()
+	modTime := time.Now()
+	for name, body := range choices.Files {
+		if err := os.WriteFile(filepath.Join(root, name), []byte(body), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(filepath.Join(root, name), modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range choices.Stale {
+		stale := modTime.Add(-time.Hour)
+		if err := os.Chtimes(filepath.Join(root, name), stale, stale); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h := newStaticHandler(root)
+
+	for _, r := range choices.Requests {
+		resp := serveStatic(h, r.Path, map[string]string{
+			fasthttp.HeaderAcceptEncoding: r.AcceptEncoding,
+			fasthttp.HeaderIfNoneMatch:    r.IfNoneMatch,
+		})
+		encoding, etag := string(resp.Header.ContentEncoding()), string(resp.Header.Peek(fasthttp.HeaderETag))
+		if resp.StatusCode() != r.Status || encoding != r.Encoding || etag != r.ETag {
+			t.Errorf("%s with Accept-Encoding %q and If-None-Match %q = %d %q %s, want %d %q %s",
+				r.Path, r.AcceptEncoding, r.IfNoneMatch, resp.StatusCode(), encoding, etag, r.Status, r.Encoding, r.ETag)
+		}
+	}
+}